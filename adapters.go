@@ -0,0 +1,102 @@
+package ghttp
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/geeksteam/GoTools/shutdown"
+	"github.com/geeksteam/SHM-Backend/core/users"
+	"github.com/geeksteam/SHM-Backend/plugins"
+	"github.com/geeksteam/ghttp/roles"
+	"github.com/geeksteam/ghttp/sessions"
+	"github.com/geeksteam/ghttp/tokens"
+	"github.com/getsentry/raven-go"
+)
+
+// Default adapters keep Router's behaviour inside SHM-Backend.
+
+// shmUsers is a default UserProvider over SHM-Backend users.
+type shmUsers struct{}
+
+func (shmUsers) Permissions(username string) ([]string, error) {
+	userInfo := users.Get(username)
+	if userInfo == nil {
+		return nil, fmt.Errorf("Can't get template for user %v", username)
+	}
+	return userInfo.GetTemplate().Grants(), nil
+}
+
+// configRoles is a default RoleProvider over roles db from config. Built-in
+// roles are created on first use, so "root" user keeps Root role.
+type configRoles struct{}
+
+var ensureRolesOnce sync.Once
+
+func (configRoles) UserRoles(username string) ([]roles.Role, error) {
+	ensureRolesOnce.Do(cfg.Roles.EnsureDefaults)
+	return cfg.Roles.UserRoles(username)
+}
+
+// configSecondFactor is a default SecondFactorProvider over second factor db
+// from config, which is read on every call, so SetConfig may be called after
+// NewRouter.
+type configSecondFactor struct{}
+
+func (configSecondFactor) Enabled(username string) (bool, error) {
+	return cfg.TwoFactor.Enabled(username)
+}
+
+func (configSecondFactor) Enroll(username string) (string, string, error) {
+	return cfg.TwoFactor.Enroll(username)
+}
+
+func (configSecondFactor) Confirm(username, code string, now time.Time) ([]string, error) {
+	return cfg.TwoFactor.Confirm(username, code, now)
+}
+
+func (configSecondFactor) Verify(username, code string, now time.Time) error {
+	return cfg.TwoFactor.Verify(username, code, now)
+}
+
+func (configSecondFactor) Disable(username string) error {
+	return cfg.TwoFactor.Disable(username)
+}
+
+// configTokens is a default TokenProvider over tokens db from config, which is
+// read on every call.
+type configTokens struct{}
+
+func (configTokens) Authenticate(raw, IP string, now time.Time) (tokens.Token, error) {
+	return cfg.Tokens.Authenticate(raw, IP, now)
+}
+
+func (configTokens) Create(username, name string, scopes []string, expires int64, allowedIPs []string, now time.Time) (string, tokens.Token, error) {
+	return cfg.Tokens.Create(username, name, scopes, expires, allowedIPs, now)
+}
+
+func (configTokens) List(username string) ([]tokens.Token, error) {
+	return cfg.Tokens.List(username)
+}
+
+func (configTokens) Revoke(username, id string) error {
+	return cfg.Tokens.Revoke(username, id)
+}
+
+// shmPlugins is a default PostHandlerHook which triggers SHM-Backend plugins.
+type shmPlugins struct{}
+
+func (shmPlugins) AfterHandler(w http.ResponseWriter, r *http.Request, sess *sessions.Session) {
+	plugins.DefaultManager.Trigger(w, r, sess)
+}
+
+// sentryReporter is a default ErrorReporter which sends errors to Sentry.
+type sentryReporter struct{}
+
+func (sentryReporter) CaptureError(err error) {
+	raven.CaptureError(err, nil)
+}
+
+// defaultWatcher is a default LifecycleWatcher for graceful shutdown.
+var defaultWatcher LifecycleWatcher = shutdown.DefaultWatcher
@@ -0,0 +1,85 @@
+package ghttp
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/geeksteam/ghttp/hmacauth"
+	"github.com/geeksteam/ghttp/sessions"
+)
+
+// Prefixes of pseudo-sessions IDs, so they differ from cookie sessions in
+// journal and rate limits.
+const (
+	tokenSessionPrefix = "token:"
+	hmacSessionPrefix  = "hmac:"
+)
+
+// session returns session of request. Requests with "Authorization: Bearer"
+// are authenticated by API token and signed requests by HMACAuth, they get
+// pseudo-session restricted by scopes.
+func (router *Router) session(r *http.Request) (*sessions.Session, error) {
+	if signatures := router.signatures(); signatures != nil && hmacauth.IsSigned(r) {
+		return router.signedSession(r, signatures)
+	}
+	if raw, ok := bearerToken(r); ok {
+		return router.tokenSession(r, raw)
+	}
+	return sessions.SessionsStorage.Get(r)
+}
+
+func (router *Router) tokenSession(r *http.Request, raw string) (*sessions.Session, error) {
+	now := router.clock.Now()
+	token, err := router.Tokens.Authenticate(raw, clientIP(r), now)
+	if err != nil {
+		return nil, err
+	}
+	return &sessions.Session{
+		ID:           tokenSessionPrefix + token.ID,
+		IP:           clientIP(r),
+		Created:      token.Created,
+		LastActivity: now.Unix(),
+		Username:     token.Username,
+		UserAgent:    r.UserAgent(),
+		LastHandlers: make(map[string]int64),
+		Auth:         sessions.AuthToken,
+		Scopes:       token.Scopes,
+	}, nil
+}
+
+func (router *Router) signedSession(r *http.Request, signatures *hmacauth.Authenticator) (*sessions.Session, error) {
+	now := router.clock.Now()
+	id, client, err := signatures.Verify(r, now)
+	if err != nil {
+		return nil, err
+	}
+	return &sessions.Session{
+		ID:           hmacSessionPrefix + id,
+		IP:           clientIP(r),
+		Created:      now.Unix(),
+		LastActivity: now.Unix(),
+		Username:     client.Username,
+		UserAgent:    r.UserAgent(),
+		LastHandlers: make(map[string]int64),
+		Auth:         sessions.AuthHMAC,
+		Scopes:       client.Scopes,
+	}, nil
+}
+
+// signatures returns Authenticator of router, one from config if router has
+// none.
+func (router *Router) signatures() *hmacauth.Authenticator {
+	if router.Signatures != nil {
+		return router.Signatures
+	}
+	return configSignatures
+}
+
+// bearerToken returns token from Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
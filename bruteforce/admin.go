@@ -0,0 +1,135 @@
+package bruteforce
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+const (
+	// systemActor is an actor of bans made by bruteforce itself.
+	systemActor    = "bruteforce"
+	attemptsReason = "Too many requests without session"
+)
+
+var (
+	errNoIP      = errors.New("IP is not set")
+	errInvalidIP = errors.New("Invalid IP")
+)
+
+// List returns IPs banned in default Guard.
+func List() []BanInfo {
+	return Default.List()
+}
+
+// Ban bans IP in default Guard.
+func Ban(IP string, duration int64, reason string) error {
+	return Default.Ban(IP, duration, reason)
+}
+
+// BanAs bans IP in default Guard on behalf of username.
+func BanAs(IP string, duration int64, reason, username, sessionID string) error {
+	return Default.BanAs(IP, duration, reason, username, sessionID)
+}
+
+// Unban unbans IP in default Guard.
+func Unban(IP string) error {
+	return Default.Unban(IP)
+}
+
+// UnbanAs unbans IP in default Guard on behalf of username.
+func UnbanAs(IP, username, sessionID string) error {
+	return Default.UnbanAs(IP, username, sessionID)
+}
+
+// List returns currently banned IPs sorted by IP.
+func (g *Guard) List() []BanInfo {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	now := g.now()
+	result := []BanInfo{}
+	for ip, bi := range g.iPs {
+		if !bi.Permanent && bi.BannedUntil <= now {
+			continue
+		}
+		info := BanInfo{IP: ip, Reason: bi.Reason, Permanent: bi.Permanent, Bans: bi.Bans}
+		if bi.Permanent {
+			info.Remaining = PermanentBan
+		} else {
+			info.Until = bi.BannedUntil
+			info.Remaining = bi.BannedUntil - now
+		}
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].IP < result[j].IP })
+	return result
+}
+
+// Ban bans IP for duration seconds, or permanently if duration <= 0.
+func (g *Guard) Ban(IP string, duration int64, reason string) error {
+	return g.BanAs(IP, duration, reason, systemActor, "")
+}
+
+// BanAs bans IP like Ban and reports the ban as made by username in session.
+// IP is stored in canonical form to match client IPs.
+func (g *Guard) BanAs(IP string, duration int64, reason, username, sessionID string) error {
+	ip := net.ParseIP(strings.TrimSpace(IP))
+	if ip == nil {
+		return errInvalidIP
+	}
+	IP = ip.String()
+
+	g.mutex.Lock()
+	now := g.now()
+	bi := g.iPs[IP]
+	bi.Attempts = nil
+	bi.Bans++
+	bi.LastBan = now
+	bi.Reason = reason
+	if duration <= 0 {
+		bi.Permanent = true
+		duration = PermanentBan
+	} else {
+		bi.Permanent = false
+		bi.BannedUntil = now + duration
+	}
+	g.iPs[IP] = bi
+	g.dirtyIPs[IP] = true
+	g.mutex.Unlock()
+
+	g.report(Event{Type: EventBan, IP: IP, Duration: duration, Reason: reason, Actor: username, SessionID: sessionID})
+	return nil
+}
+
+// Unban lifts ban of IP and forgets its history, including failed logins.
+func (g *Guard) Unban(IP string) error {
+	return g.UnbanAs(IP, systemActor, "")
+}
+
+// UnbanAs unbans IP like Unban and reports it as made by username in session.
+func (g *Guard) UnbanAs(IP, username, sessionID string) error {
+	if ip := net.ParseIP(strings.TrimSpace(IP)); ip != nil {
+		IP = ip.String()
+	}
+
+	g.mutex.Lock()
+	_, ok := g.iPs[IP]
+	delete(g.iPs, IP)
+	g.dirtyIPs[IP] = true
+	g.mutex.Unlock()
+
+	g.loginsMutex.Lock()
+	_, okLogins := g.logins["ip:"+IP]
+	delete(g.logins, "ip:"+IP)
+	g.dirtyLogins["ip:"+IP] = true
+	g.loginsMutex.Unlock()
+
+	if !ok && !okLogins {
+		return fmt.Errorf("IP %v is not banned", IP)
+	}
+	g.report(Event{Type: EventUnban, IP: IP, Actor: username, SessionID: sessionID})
+	return nil
+}
@@ -1,77 +1,159 @@
 package bruteforce
 
-import (
-	"sync"
-	"time"
-)
-
-var (
-	cfg BruteForce
-	// Local variables
-	iPs   = make(map[string]BruteIP)
-	mutex sync.RWMutex
-)
-
-func SetConfig(c BruteForce) {
-	cfg = c
-}
+import "github.com/geeksteam/ghttp/ipfilter"
+
+// PermanentBan is a ban duration returned by Check for permanently banned IPs.
+const PermanentBan int64 = -1
 
-// Clean - set number of checks for IP to zero.
-// Used after success login and etc.
+// Clean - forget attempts of IP in default Guard.
 func Clean(IP string) {
+	Default.Clean(IP)
+}
+
+// Check checks IP in default Guard.
+func Check(IP string) (bool, int64) {
+	return Default.Check(IP)
+}
+
+// Clean - forget attempts of IP.
+// Used after success login and etc. Ban history is kept.
+func (g *Guard) Clean(IP string) {
 	// Make it atomic
-	mutex.Lock()
-	defer mutex.Unlock()
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 
-	iPs[IP] = BruteIP{
-		Timestamp: time.Now().Unix(),
-		Attempts:  1,
+	bi, ok := g.iPs[IP]
+	if !ok {
+		return
+	}
+	bi.Attempts = nil
+	g.dirtyIPs[IP] = true
+	if bi.Bans == 0 && !bi.Permanent {
+		delete(g.iPs, IP)
+		return
 	}
+	g.iPs[IP] = bi
 }
 
 // Check checks given IP according to it's activity history. Returns true if
-// ip is still doesn't have to be banned.
-func Check(IP string) (bool, int64) {
+// ip is still doesn't have to be banned, otherwise returns false and remaining
+// ban duration in seconds (PermanentBan for permanent bans).
+func (g *Guard) Check(IP string) (bool, int64) {
+	// Allowed networks are never banned.
+	if ipfilter.IsAllowed(IP) {
+		return true, -1
+	}
+	// Expired BruteIP instances are cleaned in background.
+	g.startWorker()
+
+	ok, duration, banned := g.check(IP)
+	if banned {
+		g.report(Event{Type: EventBan, IP: IP, Duration: duration, Reason: attemptsReason, Actor: systemActor})
+	}
+	return ok, duration
+}
 
-	// Clean all expired BruteIP instances.
-	clean()
+// check counts attempt of IP, returns true if IP is not banned and whether it
+// has been banned by this attempt.
+func (g *Guard) check(IP string) (bool, int64, bool) {
 	// Make it atomic
-	mutex.Lock()
-	defer mutex.Unlock()
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 
-	bi, ok := iPs[IP]
+	now := g.now()
+	bi := g.iPs[IP]
+	g.decay(&bi, now)
 
-	// No BruteIP instances for given IP - create new.
-	if !ok {
-		iPs[IP] = BruteIP{
-			Timestamp: time.Now().Unix(),
-			Attempts:  1,
+	// Requests during ban don't prolong it.
+	if bi.Permanent {
+		return false, PermanentBan, false
+	}
+	if bi.BannedUntil > now {
+		return false, bi.BannedUntil - now, false
+	}
+
+	g.dirtyIPs[IP] = true
+
+	// Count attempts in sliding window.
+	bi.Attempts = append(g.windowAttempts(bi.Attempts, now), now)
+
+	// Ban condition: given IP made more attempts that are allowed within window.
+	if len(bi.Attempts) > g.cfg.BlockAttempts {
+		bi.Attempts = nil
+		bi.Bans++
+		bi.LastBan = now
+		bi.Reason = attemptsReason
+		if g.cfg.PermanentBanAfter > 0 && bi.Bans >= g.cfg.PermanentBanAfter {
+			bi.Permanent = true
+			g.iPs[IP] = bi
+			return false, PermanentBan, true
 		}
-		return true, -1
+		bi.BannedUntil = now + g.banTime(bi.Bans)
+		g.iPs[IP] = bi
+		return false, bi.BannedUntil - now, true
 	}
-	// Ban condition: given IP made more attempts that are allowed and it's
-	// ban has not expired.
-	bi.Timestamp = time.Now().Unix()
+	g.iPs[IP] = bi
+	return true, -1, false
+}
+
+// Remove IPs which are not banned and have nothing to remember
+func (g *Guard) clean() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 
-	if bi.Attempts > cfg.BlockAttempts {
-		iPs[IP] = bi
-		return false, cfg.BanTime
+	now := g.now()
+	for k, v := range g.iPs {
+		g.decay(&v, now)
+		v.Attempts = g.windowAttempts(v.Attempts, now)
+		if !v.Permanent && v.BannedUntil <= now && v.Bans == 0 && len(v.Attempts) == 0 {
+			delete(g.iPs, k)
+			g.dirtyIPs[k] = true
+			continue
+		}
+		g.iPs[k] = v
 	}
-	// Ban attempts are not exceeded - update corresponding BruteIP instancesinfo.
-	bi.Attempts++
-	iPs[IP] = bi
-	return true, -1
+}
 
+// windowAttempts drops attempts which are out of sliding window.
+func (g *Guard) windowAttempts(attempts []int64, now int64) []int64 {
+	window := g.cfg.Window
+	if window <= 0 {
+		window = g.cfg.BanTime
+	}
+	for i, t := range attempts {
+		if now-t < window {
+			return attempts[i:]
+		}
+	}
+	return nil
 }
 
-// Remove expired banned ips
-func clean() {
-	mutex.Lock()
-	defer mutex.Unlock()
-	for k, v := range iPs {
-		// Check if current v (BruteIP) ban time has expired.
-		if time.Now().Unix() > v.Timestamp+cfg.BanTime {
-			delete(iPs, k)
+// banTime returns ban duration for n-th ban: BanTime doubled on each repeated
+// ban up to MaxBanTime.
+func (g *Guard) banTime(bans int) int64 {
+	duration := g.cfg.BanTime
+	for i := 1; i < bans; i++ {
+		if g.cfg.MaxBanTime > 0 && duration*2 >= g.cfg.MaxBanTime {
+			return g.cfg.MaxBanTime
 		}
+		duration *= 2
+	}
+	return duration
+}
+
+// decay forgets one ban for every BanDecay seconds passed since last ban.
+func (g *Guard) decay(bi *BruteIP, now int64) {
+	if g.cfg.BanDecay <= 0 || bi.Bans == 0 || bi.Permanent || bi.BannedUntil > now {
+		return
+	}
+	n := (now - bi.LastBan) / g.cfg.BanDecay
+	if n <= 0 {
+		return
+	}
+	if int64(bi.Bans) <= n {
+		bi.Bans = 0
+		return
 	}
+	bi.Bans -= int(n)
+	bi.LastBan += n * g.cfg.BanDecay
 }
@@ -0,0 +1,234 @@
+package bruteforce_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geeksteam/ghttp/bruteforce"
+	"github.com/geeksteam/ghttp/ghttptest"
+)
+
+// newGuard returns Guard, which is closed on test cleanup.
+func newGuard(t *testing.T) (*bruteforce.Guard, *ghttptest.FakeClock) {
+	clock := ghttptest.NewFakeClock(time.Unix(1500000000, 0))
+	g := bruteforce.New(bruteforce.BruteForce{
+		BlockAttempts: 2,
+		BanTime:       60,
+		Window:        60,
+		MaxBanTime:    600,
+		BanDecay:      3600,
+	})
+	g.SetClock(clock)
+	t.Cleanup(func() { g.Close() })
+	return g, clock
+}
+
+func TestBanExpiry(t *testing.T) {
+	t.Parallel()
+	g, clock := newGuard(t)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := g.Check("10.0.0.1"); !ok {
+			t.Fatalf("attempt %v banned", i)
+		}
+	}
+	if ok, d := g.Check("10.0.0.1"); ok || d != 60 {
+		t.Fatalf("expected 60 sec. ban, got %v %v", ok, d)
+	}
+
+	// Requests during ban don't prolong it
+	clock.Add(30 * time.Second)
+	if ok, d := g.Check("10.0.0.1"); ok || d != 30 {
+		t.Fatalf("expected 30 sec. remaining, got %v %v", ok, d)
+	}
+
+	clock.Add(30 * time.Second)
+	if ok, _ := g.Check("10.0.0.1"); !ok {
+		t.Fatal("ban has not expired")
+	}
+
+	// Repeated ban is doubled
+	g.Check("10.0.0.1")
+	if ok, d := g.Check("10.0.0.1"); ok || d != 120 {
+		t.Fatalf("expected 120 sec. ban, got %v %v", ok, d)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	t.Parallel()
+	g, clock := newGuard(t)
+	g.SetTimeout("/api/support/bugreport", 300, "POST")
+
+	if err := g.CheckTimeout("POST", "/api/support/bugreport", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.CheckTimeout("POST", "/api/support/bugreport", "bob"); err == nil {
+		t.Fatal("second run within timeout allowed")
+	}
+	if err := g.CheckTimeout("POST", "/api/support/bugreport", "alice"); err != nil {
+		t.Fatal("timeout is shared between users")
+	}
+	if err := g.CheckTimeout("GET", "/api/support/bugreport", "bob"); err != nil {
+		t.Fatal("timeout applied to other method")
+	}
+
+	clock.Add(300 * time.Second)
+	if err := g.CheckTimeout("POST", "/api/support/bugreport", "bob"); err != nil {
+		t.Fatal("timeout has not expired")
+	}
+}
+
+func TestCheckTimeoutAllMethods(t *testing.T) {
+	t.Parallel()
+	g, clock := newGuard(t)
+	g.SetTimeout("/api/support/bugreport", 300)
+
+	if err := g.CheckTimeout("GET", "/api/support/bugreport", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	// Alternating methods don't bypass timeout of all methods
+	if err := g.CheckTimeout("POST", "/api/support/bugreport", "bob"); err == nil {
+		t.Fatal("run with other method within timeout allowed")
+	}
+	if err := g.CheckTimeout("GET", "/api/support/bugreport", "bob"); err == nil {
+		t.Fatal("second run within timeout allowed")
+	}
+
+	clock.Add(300 * time.Second)
+	if err := g.CheckTimeout("POST", "/api/support/bugreport", "bob"); err != nil {
+		t.Fatal("timeout has not expired")
+	}
+}
+
+func TestBanAs(t *testing.T) {
+	t.Parallel()
+	g, _ := newGuard(t)
+
+	if err := g.BanAs("not an ip", 60, "test", "admin", ""); err == nil {
+		t.Error("Invalid IP should be refused")
+	}
+	if err := g.BanAs(" 2001:DB8:0:0::1 ", 60, "test", "admin", ""); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := g.Check("2001:db8::1"); ok {
+		t.Error("IP written in another form should be banned")
+	}
+	if err := g.Unban("2001:db8:0::1"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLogLine(t *testing.T) {
+	e := bruteforce.Event{Type: bruteforce.EventBan, IP: "1.2.3.4\n2016-01-01T00:00:00Z ghttp-bruteforce: BAN ip=5.6.7.8", Actor: "admin"}
+	if line := e.LogLine(); strings.Count(line, "\n") != 1 || !strings.Contains(line, `ip="1.2.3.4\n`) {
+		t.Errorf("IP is not quoted in %q", line)
+	}
+	e.IP = "2001:db8::1"
+	if line := e.LogLine(); !strings.Contains(line, " ip=2001:db8::1 ") {
+		t.Errorf("Valid IP should not be quoted in %q", line)
+	}
+}
+
+func TestBanLogClose(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "bruteforce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	banLog := filepath.Join(dir, "ban.log")
+
+	g := bruteforce.New(bruteforce.BruteForce{BanTime: 60, BanLog: banLog})
+	g.Ban("10.0.0.1", 60, "test")
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ban log is reopened after Close
+	os.Remove(banLog)
+	g.Ban("10.0.0.2", 60, "test")
+	defer g.Close()
+	data, err := ioutil.ReadFile(banLog)
+	if err != nil || !strings.Contains(string(data), "ip=10.0.0.2") {
+		t.Errorf("Ban log is not reopened: %q, %v", data, err)
+	}
+}
+
+// savesStore is a Store which reports saves.
+type savesStore struct {
+	saves chan bool
+}
+
+func (s savesStore) Load() (map[string]bruteforce.BruteIP, map[string]bruteforce.LoginAttempts, error) {
+	return nil, nil, nil
+}
+
+func (s savesStore) Save(ips map[string]*bruteforce.BruteIP, logins map[string]*bruteforce.LoginAttempts) error {
+	s.saves <- true
+	return nil
+}
+
+func (s savesStore) Close() error { return nil }
+
+func TestWorkerRestart(t *testing.T) {
+	t.Parallel()
+	g := bruteforce.New(bruteforce.BruteForce{BanTime: 60, FlushInterval: 1})
+	defer g.Close()
+	if err := g.Load(); err != nil {
+		t.Fatal(err)
+	}
+	g.Close()
+
+	// Worker saves changes after Load following Close
+	s := savesStore{saves: make(chan bool, 10)}
+	g.SetStore(s)
+	if err := g.Load(); err != nil {
+		t.Fatal(err)
+	}
+	g.Ban("10.0.0.1", 60, "test")
+	select {
+	case <-s.saves:
+	case <-time.After(3 * time.Second):
+		t.Error("Changes are not saved by restarted worker")
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "bruteforce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := bruteforce.BruteForce{
+		BanTime:          60,
+		BoltDBBruteForce: filepath.Join(dir, "main.db"),
+		BucketForIPs:     "BruteIPs",
+		BucketForLogins:  "BruteLogins",
+		DataEncoding:     "json",
+	}
+
+	g := bruteforce.New(c)
+	defer g.Close()
+	if err := g.Load(); err != nil {
+		t.Fatal(err)
+	}
+	g.Ban("10.0.0.1", 60, "test")
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bans are loaded by next Guard after Close released db
+	g = bruteforce.New(c)
+	defer g.Close()
+	if err := g.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := g.Check("10.0.0.1"); ok {
+		t.Error("Ban is not saved")
+	}
+}
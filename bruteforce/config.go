@@ -1,8 +1,24 @@
 package bruteforce
 
 type BruteForce struct {
-	BlockAttempts int    `default:"10" comment:"How much attempts before ban"`
-	BanTime       int64  `default:"600" comment:"How much seconds will be banned after failed attempts"`
-	DataEncoding  string `default:"mspack" comment:"Encoding of values for boltdb storage. Values:[mspack, json]"`
-	Timeouts      map[string]int64
+	BlockAttempts         int              `default:"10" comment:"How much attempts before ban"`
+	BanTime               int64            `default:"600" comment:"How much seconds will be banned after failed attempts"`
+	Window                int64            `default:"600" comment:"Sliding window in seconds in which attempts are counted"`
+	MaxBanTime            int64            `default:"86400" comment:"Max ban seconds, ban time doubles on every repeated ban"`
+	BanDecay              int64            `default:"86400" comment:"Every BanDecay seconds without ban one ban is forgotten"`
+	PermanentBanAfter     int              `default:"0" comment:"Number of bans after which IP is banned permanently, 0 - never"`
+	BoltDBBruteForce      string           `default:"./db/bruteforce.db" comment:"Path to bolt db for bans, empty - keep bans in memory only"`
+	BucketForIPs          string           `default:"BruteIPs" comment:"Name of bucket which holds IPs activity"`
+	BucketForLogins       string           `default:"BruteLogins" comment:"Name of bucket which holds failed logins"`
+	FlushInterval         int64            `default:"5" comment:"How often in seconds changes are written to bolt db"`
+	CleanInterval         int64            `default:"60" comment:"How often in seconds expired entries are removed"`
+	BanLog                string           `default:"" comment:"Path to log of bans in fail2ban parseable format, empty - don't write"`
+	DataEncoding          string           `default:"mspack" comment:"Encoding of values for boltdb storage. Values:[mspack, json]"`
+	LoginDelayAttempts    int              `default:"3" comment:"How much failed logins before progressive delays start"`
+	LoginDelay            int64            `default:"1" comment:"First delay in seconds after failed login, doubles with every next failure"`
+	LoginMaxDelay         int64            `default:"60" comment:"Max delay in seconds between failed logins"`
+	LoginUserLockAttempts int              `default:"10" comment:"How much failed logins for single username before lockout"`
+	LoginIPLockAttempts   int              `default:"30" comment:"How much failed logins from single IP before lockout"`
+	LoginLockTime         int64            `default:"900" comment:"How much seconds login is locked, also how long failed logins are remembered"`
+	Timeouts              map[string]int64 `comment:"Seconds between runs of handler for single user. Keys are route patterns, optionally with method: 'POST /api/messages/send'"`
 }
@@ -0,0 +1,152 @@
+package bruteforce
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/geeksteam/GoTools/logger"
+	"github.com/geeksteam/ghttp/journal"
+)
+
+// Event types.
+const (
+	EventBan       = "BAN"        // IP banned
+	EventUnban     = "UNBAN"      // IP unbanned
+	EventLoginLock = "LOGIN_LOCK" // Logins for username or from IP locked
+)
+
+// Event describes bans and lockouts made by bruteforce or by admins.
+type Event struct {
+	Type      string
+	Time      time.Time
+	IP        string
+	Username  string // Locked username for login lockouts
+	Duration  int64  // Seconds, PermanentBan for permanent bans
+	Reason    string
+	Actor     string // Who made it, "bruteforce" for automatic bans
+	SessionID string // Actor's session
+}
+
+// OnBan subscribes f to events of default Guard.
+func OnBan(f func(Event)) {
+	Default.OnBan(f)
+}
+
+// OnBan subscribes f to bruteforce events. Subscribers are called
+// synchronously, so they should not block.
+func (g *Guard) OnBan(f func(Event)) {
+	g.subscribersMutex.Lock()
+	defer g.subscribersMutex.Unlock()
+	g.subscribers = append(g.subscribers, f)
+}
+
+// String returns human readable event description.
+func (e Event) String() string {
+	switch e.Type {
+	case EventUnban:
+		return "Unbanned IP " + e.IP
+	case EventLoginLock:
+		if e.Username != "" {
+			return fmt.Sprintf("Login for user %v locked for %v sec., last attempt from %v: %v", e.Username, e.Duration, e.IP, e.Reason)
+		}
+		return fmt.Sprintf("Logins from IP %v locked for %v sec.: %v", e.IP, e.Duration, e.Reason)
+	}
+	if e.Duration == PermanentBan {
+		return fmt.Sprintf("Banned IP %v permanently: %v", e.IP, e.Reason)
+	}
+	return fmt.Sprintf("Banned IP %v for %v sec.: %v", e.IP, e.Duration, e.Reason)
+}
+
+// LogLine returns event in stable format for fail2ban and the like, e.g.
+//
+//	2016-01-02T15:04:05Z ghttp-bruteforce: BAN ip=1.2.3.4 duration=600 user="" by="bruteforce" reason="Too many requests without session"
+//
+// fail2ban failregex: ghttp-bruteforce: BAN ip=<HOST>
+//
+// IP is quoted if it's not a valid IP, so it can't inject lines.
+func (e Event) LogLine() string {
+	ip := e.IP
+	if net.ParseIP(ip) == nil {
+		ip = strconv.Quote(ip)
+	}
+	return fmt.Sprintf("%v ghttp-bruteforce: %v ip=%v duration=%v user=%v by=%v reason=%v\n",
+		e.Time.UTC().Format(time.RFC3339), e.Type, ip, e.Duration,
+		strconv.Quote(e.Username), strconv.Quote(e.Actor), strconv.Quote(e.Reason))
+}
+
+// report journals event under its actor with target in Extra, writes it to ban
+// log and notifies subscribers.
+func (g *Guard) report(e Event) {
+	e.Time = g.clock.Now()
+	message := e.String()
+
+	logger.Warning(message)
+	err := journal.Add(journal.Operation{
+		SessionID: e.SessionID,
+		Date:      e.Time.Format(journal.TimeLayout),
+		Username:  e.Actor,
+		Operation: "bruteforce",
+		Content:   message,
+		Extra:     e.target(),
+	})
+	if err != nil {
+		logger.Error("Can't add bruteforce operation to journal: " + err.Error())
+	}
+
+	if err := g.writeBanLog(e); err != nil {
+		logger.Error("Can't write bruteforce ban log: " + err.Error())
+	}
+
+	g.subscribersMutex.RLock()
+	defer g.subscribersMutex.RUnlock()
+	for _, f := range g.subscribers {
+		f(e)
+	}
+}
+
+// target returns IP and locked username of event for journal.
+func (e Event) target() string {
+	if e.Username == "" {
+		return "ip=" + e.IP
+	}
+	return "ip=" + e.IP + " user=" + e.Username
+}
+
+// writeBanLog appends event to BanLog file if it is set.
+func (g *Guard) writeBanLog(e Event) error {
+	if g.cfg.BanLog == "" {
+		return nil
+	}
+
+	g.banLogMutex.Lock()
+	defer g.banLogMutex.Unlock()
+
+	if g.banLog == nil || g.banLog.Name() != g.cfg.BanLog {
+		if g.banLog != nil {
+			g.banLog.Close()
+		}
+		f, err := os.OpenFile(g.cfg.BanLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			g.banLog = nil
+			return err
+		}
+		g.banLog = f
+	}
+	_, err := g.banLog.WriteString(e.LogLine())
+	return err
+}
+
+// closeBanLog closes BanLog file, it's reopened on next event.
+func (g *Guard) closeBanLog() error {
+	g.banLogMutex.Lock()
+	defer g.banLogMutex.Unlock()
+	if g.banLog == nil {
+		return nil
+	}
+	err := g.banLog.Close()
+	g.banLog = nil
+	return err
+}
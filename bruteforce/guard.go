@@ -0,0 +1,84 @@
+package bruteforce
+
+import (
+	"os"
+	"sync"
+
+	"github.com/geeksteam/ghttp/clock"
+)
+
+// Guard protects a router against bruteforce. Each Guard has own config, store
+// and clock, so several routers in one process don't share bans.
+type Guard struct {
+	cfg   BruteForce
+	store Store // nil - state is kept in memory only
+	clock clock.Clock
+
+	iPs      map[string]BruteIP
+	dirtyIPs map[string]bool // Keys changed since last flush
+	mutex    sync.RWMutex
+
+	logins      map[string]LoginAttempts // Keyed by "user:<username>" and "ip:<IP>"
+	dirtyLogins map[string]bool
+	loginsMutex sync.Mutex
+
+	routeTimeouts map[string]int64 // Timeouts set by handlers in addition to cfg.Timeouts
+	lastRuns      map[string]int64 // Unixtime of last handler's run keyed by user and route
+	timeoutsMutex sync.Mutex
+
+	subscribers      []func(Event)
+	subscribersMutex sync.RWMutex
+
+	banLog      *os.File
+	banLogMutex sync.Mutex
+
+	// Background worker, it's restarted by Load or Check after Close. Own
+	// mutex is used as worker takes mutex to clean.
+	workerRunning bool
+	stopChan      chan bool
+	doneChan      chan bool
+	workerMutex   sync.Mutex
+}
+
+// Default is a Guard used by package level functions.
+var Default = New(BruteForce{})
+
+// New is a Guard constructor. State is kept in memory until store is set or
+// Load opens BoltDB from config.
+func New(c BruteForce) *Guard {
+	return &Guard{
+		cfg:           c,
+		clock:         clock.Real,
+		iPs:           make(map[string]BruteIP),
+		dirtyIPs:      make(map[string]bool),
+		logins:        make(map[string]LoginAttempts),
+		dirtyLogins:   make(map[string]bool),
+		routeTimeouts: make(map[string]int64),
+		lastRuns:      make(map[string]int64),
+	}
+}
+
+// SetConfig sets config of default Guard.
+func SetConfig(c BruteForce) {
+	Default.SetConfig(c)
+}
+
+// SetConfig sets Guard's config. Should be called before Load.
+func (g *Guard) SetConfig(c BruteForce) {
+	g.cfg = c
+}
+
+// SetStore sets storage for Guard's state. Should be called before Load.
+func (g *Guard) SetStore(s Store) {
+	g.store = s
+}
+
+// SetClock sets Guard's clock.
+func (g *Guard) SetClock(c clock.Clock) {
+	g.clock = c
+}
+
+// now returns current unixtime by Guard's clock.
+func (g *Guard) now() int64 {
+	return g.clock.Now().Unix()
+}
@@ -0,0 +1,70 @@
+package bruteforce
+
+import (
+	"net/http"
+
+	"github.com/geeksteam/SHM-Backend/panicerr"
+	"github.com/geeksteam/ghttp/handlerutils"
+	"github.com/geeksteam/ghttp/sessions"
+)
+
+// BanRequest is a JSON request for HandleBan and HandleUnban.
+type BanRequest struct {
+	IP       string
+	Duration int64 // Seconds, 0 - permanent ban
+	Reason   string
+}
+
+// HandleList lists bans of default Guard.
+func HandleList(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	Default.HandleList(w, r, s)
+}
+
+// HandleBan bans IP in default Guard.
+func HandleBan(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	Default.HandleBan(w, r, s)
+}
+
+// HandleUnban unbans IP in default Guard.
+func HandleUnban(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	Default.HandleUnban(w, r, s)
+}
+
+// HandleList writes currently banned IPs as JSON. Handlers of this file are
+// supposed to be mounted with Router.HandleInternalFunc for admins only.
+func (g *Guard) HandleList(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	handlerUtils.WriteJSONBody(w, g.List())
+}
+
+// HandleBan bans IP from JSON BanRequest.
+func (g *Guard) HandleBan(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess, err := s.Get(r)
+	if err != nil {
+		panicerr.Core.Auth(err.Error())
+	}
+
+	req := BanRequest{}
+	handlerUtils.ParseJSONBody(w, r, &req)
+	if req.IP == "" {
+		panicerr.Handlers.BadRequest(errNoIP)
+	}
+	if err := g.BanAs(req.IP, req.Duration, req.Reason, sess.Username, sess.ID); err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	handlerUtils.SendOkStatus(w)
+}
+
+// HandleUnban unbans IP from JSON BanRequest.
+func (g *Guard) HandleUnban(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess, err := s.Get(r)
+	if err != nil {
+		panicerr.Core.Auth(err.Error())
+	}
+
+	req := BanRequest{}
+	handlerUtils.ParseJSONBody(w, r, &req)
+	if err := g.UnbanAs(req.IP, sess.Username, sess.ID); err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	handlerUtils.SendOkStatus(w)
+}
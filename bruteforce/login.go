@@ -0,0 +1,142 @@
+package bruteforce
+
+import (
+	"fmt"
+
+	"github.com/geeksteam/ghttp/ipfilter"
+)
+
+// LoginCheck checks login attempt in default Guard.
+func LoginCheck(IP, username string) (bool, int64) {
+	return Default.LoginCheck(IP, username)
+}
+
+// LoginFailed registers failed login in default Guard.
+func LoginFailed(IP, username string) int64 {
+	return Default.LoginFailed(IP, username)
+}
+
+// LoginSucceeded clears failed logins in default Guard.
+func LoginSucceeded(IP, username string) {
+	Default.LoginSucceeded(IP, username)
+}
+
+// LoginCheck checks whether login attempt for username from IP may be made
+// now. Returns false and seconds to wait if username or IP is delayed or locked.
+// Should be called by login handler before checking password.
+func (g *Guard) LoginCheck(IP, username string) (bool, int64) {
+	if ipfilter.IsAllowed(IP) {
+		return true, -1
+	}
+	g.loginsMutex.Lock()
+	defer g.loginsMutex.Unlock()
+
+	now := g.now()
+	wait := longest(g.loginWait(g.logins["user:"+username], now), g.loginWait(g.logins["ip:"+IP], now))
+	if wait > 0 {
+		return false, wait
+	}
+	return true, -1
+}
+
+// LoginFailed registers failed login for username from IP. Per-username and
+// per-IP failures are counted separately, so password guessing on one account
+// from many IPs is detected as well as guessing on many accounts from one IP.
+// Returns seconds to wait before next attempt.
+func (g *Guard) LoginFailed(IP, username string) int64 {
+	if ipfilter.IsAllowed(IP) {
+		return 0
+	}
+	g.loginsMutex.Lock()
+	now := g.now()
+	userWait, userLocked := g.loginFailed("user:"+username, g.cfg.LoginUserLockAttempts, now)
+	ipWait, ipLocked := g.loginFailed("ip:"+IP, g.cfg.LoginIPLockAttempts, now)
+	g.loginsMutex.Unlock()
+
+	if userLocked {
+		g.report(Event{Type: EventLoginLock, IP: IP, Username: username, Duration: userWait, Actor: systemActor,
+			Reason: fmt.Sprintf("%v failed logins for user", g.cfg.LoginUserLockAttempts)})
+	}
+	if ipLocked {
+		g.report(Event{Type: EventLoginLock, IP: IP, Duration: ipWait, Actor: systemActor,
+			Reason: fmt.Sprintf("%v failed logins from IP, last as user %v", g.cfg.LoginIPLockAttempts, username)})
+	}
+	return longest(userWait, ipWait)
+}
+
+// LoginSucceeded clears failed logins history for username. IP history is kept
+// and expires on its own, so a single valid account can't be used to reset it.
+func (g *Guard) LoginSucceeded(IP, username string) {
+	g.loginsMutex.Lock()
+	defer g.loginsMutex.Unlock()
+
+	delete(g.logins, "user:"+username)
+	g.dirtyLogins["user:"+username] = true
+}
+
+// loginFailed increments failures for key and returns seconds to wait and
+// whether key has been locked by this failure.
+func (g *Guard) loginFailed(key string, lockAttempts int, now int64) (int64, bool) {
+	la := g.logins[key]
+
+	// Forget failures which are older than lock time.
+	if now-la.LastFailure > g.cfg.LoginLockTime && la.LockedUntil < now {
+		la = LoginAttempts{}
+	}
+	la.Failures++
+	la.LastFailure = now
+	locked := false
+	if lockAttempts > 0 && la.Failures >= lockAttempts && la.LockedUntil < now {
+		la.LockedUntil = now + g.cfg.LoginLockTime
+		locked = true
+	}
+	g.logins[key] = la
+	g.dirtyLogins[key] = true
+
+	return g.loginWait(la, now), locked
+}
+
+// cleanLogins removes failed logins which are forgotten already.
+func (g *Guard) cleanLogins() {
+	g.loginsMutex.Lock()
+	defer g.loginsMutex.Unlock()
+
+	now := g.now()
+	for k, v := range g.logins {
+		if now-v.LastFailure > g.cfg.LoginLockTime && v.LockedUntil < now {
+			delete(g.logins, k)
+			g.dirtyLogins[k] = true
+		}
+	}
+}
+
+// loginWait returns seconds to wait before next login attempt: the rest of
+// lockout or progressive delay which doubles with every failure over
+// LoginDelayAttempts.
+func (g *Guard) loginWait(la LoginAttempts, now int64) int64 {
+	if la.LockedUntil > now {
+		return la.LockedUntil - now
+	}
+	if now-la.LastFailure > g.cfg.LoginLockTime || la.Failures < g.cfg.LoginDelayAttempts {
+		return 0
+	}
+
+	delay := g.cfg.LoginDelay
+	for i := g.cfg.LoginDelayAttempts; i < la.Failures && delay < g.cfg.LoginMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > g.cfg.LoginMaxDelay {
+		delay = g.cfg.LoginMaxDelay
+	}
+	if wait := la.LastFailure + delay - now; wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func longest(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,254 @@
+package bruteforce
+
+import (
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/geeksteam/GoTools/boltdb"
+	"github.com/geeksteam/GoTools/logger"
+)
+
+const dbMode = os.FileMode(0600)
+
+// Store persists Guard's state. Save gets entries changed since last save,
+// nil values are deleted entries.
+type Store interface {
+	Load() (map[string]BruteIP, map[string]LoginAttempts, error)
+	Save(ips map[string]*BruteIP, logins map[string]*LoginAttempts) error
+	Close() error
+}
+
+// boltStore keeps state in BoltDB.
+type boltStore struct {
+	db       *bolt.DB
+	ips      []byte
+	logins   []byte
+	encoding string
+}
+
+// NewBoltStore opens BoltDB at path and returns Store keeping state in it.
+func NewBoltStore(path, bucketForIPs, bucketForLogins, encoding string) (Store, error) {
+	db, err := bolt.Open(path, dbMode, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s := &boltStore{db: db, ips: []byte(bucketForIPs), logins: []byte(bucketForLogins), encoding: encoding}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(s.ips); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(s.logins)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *boltStore) Load() (map[string]BruteIP, map[string]LoginAttempts, error) {
+	ips := make(map[string]BruteIP)
+	logins := make(map[string]LoginAttempts)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		tx.Bucket(s.ips).ForEach(func(k, v []byte) error {
+			bi := BruteIP{}
+			if err := boltdb.DecodeValue(v, &bi, s.encoding); err != nil {
+				logger.Warning("Can't decode bruteforce entry for " + string(k) + ": " + err.Error())
+				return nil
+			}
+			ips[string(k)] = bi
+			return nil
+		})
+		return tx.Bucket(s.logins).ForEach(func(k, v []byte) error {
+			la := LoginAttempts{}
+			if err := boltdb.DecodeValue(v, &la, s.encoding); err != nil {
+				logger.Warning("Can't decode bruteforce login entry for " + string(k) + ": " + err.Error())
+				return nil
+			}
+			logins[string(k)] = la
+			return nil
+		})
+	})
+	return ips, logins, err
+}
+
+func (s *boltStore) Save(ips map[string]*BruteIP, logins map[string]*LoginAttempts) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.ips)
+		for k, v := range ips {
+			if err := s.put(bucket, k, v, v == nil); err != nil {
+				return err
+			}
+		}
+		bucket = tx.Bucket(s.logins)
+		for k, v := range logins {
+			if err := s.put(bucket, k, v, v == nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// put encodes and puts value or deletes key.
+func (s *boltStore) put(bucket *bolt.Bucket, key string, value interface{}, isDeleted bool) error {
+	if isDeleted {
+		return bucket.Delete([]byte(key))
+	}
+	data, err := boltdb.EncodeValue(value, s.encoding)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), data)
+}
+
+// Load loads default Guard's state.
+func Load() error {
+	return Default.Load()
+}
+
+// Close saves default Guard's state and closes its store.
+func Close() error {
+	return Default.Close()
+}
+
+// Load opens BoltDB from config if no store is set, loads saved bans from store
+// and starts background worker which saves changes and prunes expired entries.
+func (g *Guard) Load() error {
+	if g.store == nil && g.cfg.BoltDBBruteForce != "" {
+		s, err := NewBoltStore(g.cfg.BoltDBBruteForce, g.cfg.BucketForIPs, g.cfg.BucketForLogins, g.cfg.DataEncoding)
+		if err != nil {
+			return err
+		}
+		g.store = s
+
+		ips, logins, err := s.Load()
+		if err != nil {
+			return err
+		}
+		g.mutex.Lock()
+		for k, v := range ips {
+			g.iPs[k] = v
+		}
+		g.mutex.Unlock()
+		g.loginsMutex.Lock()
+		for k, v := range logins {
+			g.logins[k] = v
+		}
+		g.loginsMutex.Unlock()
+	}
+	g.startWorker()
+	return nil
+}
+
+// Close stops background worker, saves unsaved changes and closes store and
+// ban log.
+func (g *Guard) Close() error {
+	g.workerMutex.Lock()
+	if g.workerRunning {
+		g.stopChan <- true
+		<-g.doneChan
+		g.workerRunning = false
+	}
+	g.workerMutex.Unlock()
+
+	err := g.closeBanLog()
+	if g.store == nil {
+		return err
+	}
+	if errFlush := g.flush(); err == nil {
+		err = errFlush
+	}
+	if errClose := g.store.Close(); err == nil {
+		err = errClose
+	}
+	g.store = nil
+	return err
+}
+
+// flush saves entries changed since last flush to store.
+func (g *Guard) flush() error {
+	if g.store == nil {
+		return nil
+	}
+
+	// Copy changes, nil values are deleted entries
+	g.mutex.Lock()
+	ips := make(map[string]*BruteIP, len(g.dirtyIPs))
+	for k := range g.dirtyIPs {
+		if bi, ok := g.iPs[k]; ok {
+			ips[k] = &bi
+		} else {
+			ips[k] = nil
+		}
+	}
+	g.dirtyIPs = make(map[string]bool)
+	g.mutex.Unlock()
+
+	g.loginsMutex.Lock()
+	logins := make(map[string]*LoginAttempts, len(g.dirtyLogins))
+	for k := range g.dirtyLogins {
+		if la, ok := g.logins[k]; ok {
+			logins[k] = &la
+		} else {
+			logins[k] = nil
+		}
+	}
+	g.dirtyLogins = make(map[string]bool)
+	g.loginsMutex.Unlock()
+
+	if len(ips) == 0 && len(logins) == 0 {
+		return nil
+	}
+	return g.store.Save(ips, logins)
+}
+
+// startWorker runs flushing and pruning in background if it's not running.
+func (g *Guard) startWorker() {
+	g.workerMutex.Lock()
+	defer g.workerMutex.Unlock()
+	if g.workerRunning {
+		return
+	}
+	g.workerRunning = true
+	stop, done := make(chan bool), make(chan bool)
+	g.stopChan, g.doneChan = stop, done
+
+	go func() {
+		flushTicker := time.NewTicker(seconds(g.cfg.FlushInterval, 5))
+		cleanTicker := time.NewTicker(seconds(g.cfg.CleanInterval, 60))
+		defer flushTicker.Stop()
+		defer cleanTicker.Stop()
+
+		for {
+			select {
+			case <-flushTicker.C:
+				if err := g.flush(); err != nil {
+					logger.Error("Can't save bruteforce state: " + err.Error())
+				}
+			case <-cleanTicker.C:
+				g.clean()
+				g.cleanLogins()
+				g.cleanTimeouts()
+			case <-stop:
+				done <- true
+				return
+			}
+		}
+	}()
+}
+
+func seconds(s, def int64) time.Duration {
+	if s <= 0 {
+		s = def
+	}
+	return time.Duration(s) * time.Second
+}
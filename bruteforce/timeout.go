@@ -2,38 +2,95 @@ package bruteforce
 
 import (
 	"fmt"
-	"net/http"
-	"time"
-
-	"github.com/geeksteam/ghttp/sessions"
+	"strings"
 )
 
-var (
-	// Timeouts: api request and timeout between next run in seconds.
-	timeouts = map[string]int64{
-		"/api/messages/send":          10,
-		"/api/filemanager/pack":       10,
-		"/api/filemanager/getarchive": 10,
-		"/api/support/bugreport":      300,
-	}
-)
+// SetTimeout sets handler's timeout in default Guard.
+func SetTimeout(pattern string, timeout int64, methods ...string) {
+	Default.SetTimeout(pattern, timeout, methods...)
+}
 
-// CheckTimeout Check timeout for concrete handler
-func CheckTimeout(r *http.Request, s *sessions.Sessions) error {
-	// Check if current uri in map of timeouts
-	timeout, ok := cfg.Timeouts[r.URL.Path]
-	if !ok {
-		return nil
+// CheckTimeout checks handler's timeout in default Guard.
+func CheckTimeout(method, pattern, username string) error {
+	return Default.CheckTimeout(method, pattern, username)
+}
+
+// SetTimeout sets timeout in seconds between runs of handler with given route
+// pattern (mux path template) for a single user. Applies to listed methods or
+// to all methods if none given.
+func (g *Guard) SetTimeout(pattern string, timeout int64, methods ...string) {
+	g.timeoutsMutex.Lock()
+	defer g.timeoutsMutex.Unlock()
+
+	if len(methods) == 0 {
+		g.routeTimeouts[pattern] = timeout
+		return
 	}
+	for _, method := range methods {
+		g.routeTimeouts[timeoutKey(method, pattern)] = timeout
+	}
+}
+
+// CheckTimeout checks timeout between runs of handler with given method and
+// route pattern for user and registers current run if it is allowed.
+// Timeouts are looked up as "METHOD /pattern" first and "/pattern" then.
+// Runs are counted per found timeout, so timeout of all methods is shared by
+// them.
+func (g *Guard) CheckTimeout(method, pattern, username string) error {
+	g.timeoutsMutex.Lock()
+	defer g.timeoutsMutex.Unlock()
 
-	sess, _ := s.Get(r)
-	lastRequest, ok := sess.LastHandlers[r.RequestURI]
+	timeout, found, ok := g.getTimeout(method, pattern)
 	if !ok {
 		return nil
 	}
 
-	if time.Now().Unix()-lastRequest <= timeout {
-		return fmt.Errorf(fmt.Sprint("One request '"+r.URL.Path+"' per ", timeout, " seconds limit."))
+	now := g.now()
+	key := username + "|" + found
+	if lastRun, ok := g.lastRuns[key]; ok && now-lastRun < timeout {
+		return fmt.Errorf(fmt.Sprint("One request '"+pattern+"' per ", timeout, " seconds limit."))
 	}
+	g.lastRuns[key] = now
 	return nil
 }
+
+// cleanTimeouts deletes last runs, which are older than any timeout and
+// can't limit next runs.
+func (g *Guard) cleanTimeouts() {
+	g.timeoutsMutex.Lock()
+	defer g.timeoutsMutex.Unlock()
+
+	maxTimeout := int64(0)
+	for _, timeout := range g.routeTimeouts {
+		maxTimeout = longest(maxTimeout, timeout)
+	}
+	for _, timeout := range g.cfg.Timeouts {
+		maxTimeout = longest(maxTimeout, timeout)
+	}
+
+	now := g.now()
+	for k, lastRun := range g.lastRuns {
+		if now-lastRun >= maxTimeout {
+			delete(g.lastRuns, k)
+		}
+	}
+}
+
+// getTimeout returns timeout for method and pattern. Method specific timeouts
+// take precedence, handler's timeouts take precedence over config ones. Key
+// of found timeout is returned too.
+func (g *Guard) getTimeout(method, pattern string) (int64, string, bool) {
+	for _, key := range []string{timeoutKey(method, pattern), pattern} {
+		if timeout, ok := g.routeTimeouts[key]; ok {
+			return timeout, key, true
+		}
+		if timeout, ok := g.cfg.Timeouts[key]; ok {
+			return timeout, key, true
+		}
+	}
+	return 0, "", false
+}
+
+func timeoutKey(method, pattern string) string {
+	return strings.ToUpper(method) + " " + pattern
+}
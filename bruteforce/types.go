@@ -2,6 +2,27 @@ package bruteforce
 
 // BruteIP is a info struct, which holds information about given IP's activity history.
 type BruteIP struct {
-	Timestamp int64 // Unixtime последней попытки
-	Attempts  int   // Количество попыток
+	Attempts    []int64 // Unixtime попыток в пределах окна Window
+	BannedUntil int64   // Unixtime окончания текущего бана
+	Bans        int     // Количество банов, уменьшается каждые BanDecay секунд
+	LastBan     int64   // Unixtime последнего бана
+	Permanent   bool    // Забанен навсегда
+	Reason      string  // Причина последнего бана
+}
+
+// BanInfo describes banned IP for admins.
+type BanInfo struct {
+	IP        string
+	Reason    string
+	Permanent bool
+	Until     int64 // Unixtime of ban end, 0 for permanent bans
+	Remaining int64 // Seconds left, PermanentBan for permanent bans
+	Bans      int   // Number of bans remembered for IP
+}
+
+// LoginAttempts holds failed logins history for single username or IP.
+type LoginAttempts struct {
+	Failures    int   // Number of failed logins
+	LastFailure int64 // Unixtime of last failed login
+	LockedUntil int64 // Unixtime until login is locked
 }
@@ -0,0 +1,18 @@
+package clock
+
+import "time"
+
+// Clock tells current time. Real clock is used by default, tests may use a
+// fake one to check expiry without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is a Clock which returns system time.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
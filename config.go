@@ -3,8 +3,17 @@ package ghttp
 import (
 	"github.com/geeksteam/ghttp/api"
 	"github.com/geeksteam/ghttp/bruteforce"
+	"github.com/geeksteam/ghttp/cors"
+	"github.com/geeksteam/ghttp/csrf"
+	"github.com/geeksteam/ghttp/hmacauth"
+	"github.com/geeksteam/ghttp/ipfilter"
 	"github.com/geeksteam/ghttp/journal"
+	"github.com/geeksteam/ghttp/ratelimit"
+	"github.com/geeksteam/ghttp/roles"
+	"github.com/geeksteam/ghttp/secheaders"
 	"github.com/geeksteam/ghttp/sessions"
+	"github.com/geeksteam/ghttp/tokens"
+	"github.com/geeksteam/ghttp/twofactor"
 	"github.com/geeksteam/ghttp/utemplates"
 )
 
@@ -12,11 +21,21 @@ type Config struct {
 	MaxHandlersForUser int    `default:"30" comment:"Max allowed number of simultaneous queries for single user."`
 	Version            string `default:"0.1.1alpha"`
 	WebServerName      string `default:"SHM API server"`
+	ShowVersion        bool   `default:"false" comment:"Send Server and Version headers, they disclose product version."`
 	CacheLifetime      int    `default:"0" comment:"Cache lifetime in days for static files (images,css, etc)"`
 
 	bruteforce.BruteForce
 	journal.Journal
 	api.API
+	ratelimit.RateLimit
+	ipfilter.IPFilter
 	sessions.SessionsConf
 	utemplates.Utemplates
+	roles.Roles
+	twofactor.TwoFactor
+	tokens.Tokens
+	hmacauth.HMACAuth
+	csrf.CSRF
+	cors.CORS
+	secheaders.SecurityHeaders
 }
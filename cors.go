@@ -0,0 +1,52 @@
+package ghttp
+
+import (
+	"net/http"
+
+	"github.com/geeksteam/ghttp/cors"
+	"github.com/gorilla/mux"
+)
+
+// ServeHTTP answers CORS preflights of routes with CORS policy before any
+// checks, so they aren't counted by bruteforce as requests without session.
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if cors.IsPreflight(r) {
+		if policy := router.preflightPolicy(r); policy != nil {
+			policy.Preflight(w, r)
+			return
+		}
+	}
+	router.Router.ServeHTTP(w, r)
+}
+
+// preflightPolicy returns CORS policy of route which preflight asks for.
+func (router *Router) preflightPolicy(r *http.Request) *cors.Policy {
+	probe := *r
+	probe.Method = r.Header.Get("Access-Control-Request-Method")
+
+	var match mux.RouteMatch
+	if !router.Router.Match(&probe, &match) || match.Route == nil {
+		return nil
+	}
+
+	router.mutex.RLock()
+	defer router.mutex.RUnlock()
+	for _, rt := range router.routes {
+		if rt.Route == match.Route {
+			if rt.cors != nil {
+				return rt.cors
+			}
+			return router.corsDefault()
+		}
+	}
+	return nil
+}
+
+// corsDefault returns default CORS policy of router, one from config if
+// router has none.
+func (router *Router) corsDefault() *cors.Policy {
+	if router.CORS != nil {
+		return router.CORS
+	}
+	return configCORS
+}
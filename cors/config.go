@@ -0,0 +1,10 @@
+package cors
+
+type CORS struct {
+	AllowedOrigins   []string `comment:"Origins allowed to call API: exact (https://panel.example.com), wildcard subdomains (https://*.example.com) or *. Empty disables CORS."`
+	AllowedMethods   string   `default:"GET, POST, PUT, PATCH, DELETE" comment:"Methods allowed in cross-origin requests, comma separated"`
+	AllowedHeaders   string   `default:"Content-Type, Authorization, X-CSRF-Token" comment:"Request headers allowed in cross-origin requests, comma separated"`
+	ExposedHeaders   string   `default:"X-CSRF-Token, X-Auth-Required, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset" comment:"Response headers readable by cross-origin clients, comma separated"`
+	AllowCredentials bool     `default:"true" comment:"Allow cookies in cross-origin requests. Ignored for * origin."`
+	MaxAge           int      `default:"600" comment:"How long browsers may cache preflight responses. Seconds."`
+}
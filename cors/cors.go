@@ -0,0 +1,151 @@
+// Package cors answers CORS preflight requests and sets CORS headers of
+// responses according to Policy.
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Policy is a parsed CORS config.
+type Policy struct {
+	any         bool     // * origin
+	origins     []string // Exact origins
+	wildcards   []string // Schemes with host suffixes like "https://" and ".example.com"
+	methods     []string
+	headers     []string
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+// New parses config into Policy.
+func New(c CORS) *Policy {
+	p := &Policy{
+		methods:     split(strings.ToUpper(c.AllowedMethods)),
+		headers:     split(strings.ToLower(c.AllowedHeaders)),
+		exposed:     strings.Join(split(c.ExposedHeaders), ", "),
+		credentials: c.AllowCredentials,
+		maxAge:      strconv.Itoa(c.MaxAge),
+	}
+	for _, o := range c.AllowedOrigins {
+		o = strings.ToLower(strings.TrimRight(strings.TrimSpace(o), "/"))
+		switch {
+		case o == "*":
+			p.any = true
+		case strings.Contains(o, "://*."):
+			parts := strings.SplitN(o, "://*", 2)
+			p.wildcards = append(p.wildcards, parts[0]+"://", parts[1])
+		default:
+			p.origins = append(p.origins, o)
+		}
+	}
+	return p
+}
+
+// AllowsOrigin checks if origin like "https://panel.example.com" is allowed.
+func (p *Policy) AllowsOrigin(origin string) bool {
+	if p != nil && p.any && origin != "" {
+		return true
+	}
+	return p.AllowsExplicitOrigin(origin)
+}
+
+// AllowsExplicitOrigin checks if origin is listed in policy exactly or by host
+// wildcard, "*" is ignored. Such origins may be trusted by CSRF checks.
+func (p *Policy) AllowsExplicitOrigin(origin string) bool {
+	if p == nil || origin == "" {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	for _, o := range p.origins {
+		if o == origin {
+			return true
+		}
+	}
+	for i := 0; i < len(p.wildcards); i += 2 {
+		scheme, suffix := p.wildcards[i], p.wildcards[i+1]
+		if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, suffix) && len(origin) > len(scheme)+len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPreflight checks if request is a CORS preflight.
+func IsPreflight(r *http.Request) bool {
+	return r.Method == "OPTIONS" && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// Preflight answers preflight request with 204. Allow headers are set only if
+// origin, method and headers are allowed, otherwise browser refuses request.
+func (p *Policy) Preflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if p.setOrigin(w, r) && contains(p.methods, method) && p.allowsHeaders(r.Header.Get("Access-Control-Request-Headers")) {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.methods, ", "))
+		if len(p.headers) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.headers, ", "))
+		}
+		w.Header().Set("Access-Control-Max-Age", p.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetHeaders sets CORS headers of response to cross-origin request.
+func (p *Policy) SetHeaders(w http.ResponseWriter, r *http.Request) {
+	if p == nil || r.Header.Get("Origin") == "" {
+		return
+	}
+	w.Header().Add("Vary", "Origin")
+	if p.setOrigin(w, r) && p.exposed != "" {
+		w.Header().Set("Access-Control-Expose-Headers", p.exposed)
+	}
+}
+
+// setOrigin sets allowed origin and credentials headers if origin is allowed.
+func (p *Policy) setOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if !p.AllowsOrigin(origin) {
+		return false
+	}
+	// Credentials can't be used with * origin
+	if p.any {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return true
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if p.credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+func (p *Policy) allowsHeaders(requested string) bool {
+	for _, h := range split(strings.ToLower(requested)) {
+		if !contains(p.headers, h) {
+			return false
+		}
+	}
+	return true
+}
+
+func split(s string) []string {
+	result := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
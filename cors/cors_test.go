@@ -0,0 +1,68 @@
+package cors_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/geeksteam/ghttp/cors"
+)
+
+func TestAllowsOrigin(t *testing.T) {
+	p := cors.New(cors.CORS{AllowedOrigins: []string{"https://panel.example.com/", "https://*.example.org"}})
+	cases := map[string]bool{
+		"https://panel.example.com":  true,
+		"https://PANEL.example.com":  true,
+		"http://panel.example.com":   false,
+		"https://a.b.example.org":    true,
+		"https://example.org":        false,
+		"https://evilexample.org":    false,
+		"https://example.org.evil.x": false,
+		"":                           false,
+	}
+	for origin, allowed := range cases {
+		if p.AllowsOrigin(origin) != allowed {
+			t.Errorf("AllowsOrigin(%v) should be %v", origin, allowed)
+		}
+	}
+}
+
+func TestAllowsExplicitOrigin(t *testing.T) {
+	p := cors.New(cors.CORS{AllowedOrigins: []string{"*", "https://panel.example.com"}})
+	if !p.AllowsOrigin("https://evil.example.net") {
+		t.Error("* policy should allow any origin")
+	}
+	if p.AllowsExplicitOrigin("https://evil.example.net") {
+		t.Error("* should not make origin explicitly allowed")
+	}
+	if !p.AllowsExplicitOrigin("https://panel.example.com") {
+		t.Error("Listed origin should be explicitly allowed")
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	p := cors.New(cors.CORS{
+		AllowedOrigins:   []string{"https://panel.example.com"},
+		AllowedMethods:   "GET, POST",
+		AllowedHeaders:   "Content-Type, X-CSRF-Token",
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+
+	r := httptest.NewRequest("OPTIONS", "/api/dns/records", nil)
+	r.Header.Set("Origin", "https://panel.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	r.Header.Set("Access-Control-Request-Headers", "x-csrf-token")
+	w := httptest.NewRecorder()
+	p.Preflight(w, r)
+	if w.Code != 204 || w.Header().Get("Access-Control-Allow-Origin") != "https://panel.example.com" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" || w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("Unexpected preflight response %v %v", w.Code, w.Header())
+	}
+
+	r.Header.Set("Access-Control-Request-Method", "DELETE")
+	w = httptest.NewRecorder()
+	p.Preflight(w, r)
+	if w.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("Method should not be allowed: %v", w.Header())
+	}
+}
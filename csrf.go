@@ -0,0 +1,25 @@
+package ghttp
+
+import (
+	"net/http"
+
+	"github.com/geeksteam/SHM-Backend/panicerr"
+	"github.com/geeksteam/ghttp/handlerutils"
+	"github.com/geeksteam/ghttp/sessions"
+)
+
+// CSRFToken is a JSON response with CSRF token of session.
+type CSRFToken struct {
+	Header string // Header to send token in
+	Token  string
+}
+
+// HandleCSRFToken writes CSRF token of session for clients which can't read
+// response headers. Should be mounted with Route.AnyUser.
+func (router *Router) HandleCSRFToken(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess, err := s.Get(r)
+	if err != nil {
+		panicerr.Core.Auth(err.Error())
+	}
+	handlerUtils.WriteJSONBody(w, CSRFToken{Header: cfg.CSRF.HeaderName, Token: sess.CSRFToken})
+}
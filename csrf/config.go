@@ -0,0 +1,7 @@
+package csrf
+
+type CSRF struct {
+	Enabled        bool     `default:"true" comment:"Check CSRF token and Origin of state-changing requests with session cookie."`
+	HeaderName     string   `default:"X-CSRF-Token" comment:"Header which carries CSRF token in requests and responses."`
+	TrustedOrigins []string `comment:"Origins besides request's host allowed to make state-changing requests (https://panel.example.com)"`
+}
@@ -0,0 +1,96 @@
+// Package csrf protects cookie sessions from cross-site requests. Every
+// session has own token, which client reads from response header and sends
+// back in the same header with state-changing requests. Origin or Referer of
+// such requests should be request's host or trusted origin.
+package csrf
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	cfg   CSRF
+	mutex sync.RWMutex
+
+	errOrigin = errors.New("Cross-site request refused.")
+	errToken  = errors.New("Invalid CSRF token.")
+)
+
+// SetConfig sets config.
+func SetConfig(c CSRF) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	cfg = c
+}
+
+// Enabled checks if CSRF protection is on.
+func Enabled() bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return cfg.Enabled
+}
+
+// HeaderName returns header which carries CSRF token.
+func HeaderName() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return cfg.HeaderName
+}
+
+// Safe checks if method doesn't change state and needs no protection.
+func Safe(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// Check checks Origin (or Referer without it) and token of request against
+// session's token. Requests without both Origin and Referer rely on token.
+// Origins allowed by allowOrigin are trusted too, it may be nil.
+func Check(r *http.Request, token string, allowOrigin func(origin string) bool) error {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	source := r.Header.Get("Origin")
+	if source == "" || source == "null" {
+		source = r.Header.Get("Referer")
+	}
+	if source != "" && !trusted(r, source, allowOrigin) {
+		return errOrigin
+	}
+
+	sent := r.Header.Get(cfg.HeaderName)
+	if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		return errToken
+	}
+	return nil
+}
+
+// trusted checks if origin or referer URL belongs to request's host or
+// trusted origins.
+func trusted(r *http.Request, source string, allowOrigin func(string) bool) bool {
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	if allowOrigin != nil && allowOrigin(origin) {
+		return true
+	}
+	for _, o := range cfg.TrustedOrigins {
+		if strings.ToLower(strings.TrimRight(o, "/")) == origin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package csrf_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/geeksteam/ghttp/csrf"
+)
+
+func TestCheck(t *testing.T) {
+	csrf.SetConfig(csrf.CSRF{
+		Enabled:        true,
+		HeaderName:     "X-CSRF-Token",
+		TrustedOrigins: []string{"https://panel.example.com/"},
+	})
+
+	cases := []struct {
+		origin, referer, token string
+		ok                     bool
+	}{
+		{"", "", "secret", true},
+		{"", "", "wrong", false},
+		{"", "", "", false},
+		{"https://example.com", "", "secret", true},
+		{"https://panel.example.com", "", "secret", true},
+		{"https://evil.com", "", "secret", false},
+		{"", "https://evil.com/page", "secret", false},
+		{"", "https://example.com/page", "secret", true},
+		{"null", "https://evil.com/page", "secret", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "https://example.com/api/dns/records", nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		if c.referer != "" {
+			r.Header.Set("Referer", c.referer)
+		}
+		if c.token != "" {
+			r.Header.Set("X-CSRF-Token", c.token)
+		}
+		if err := csrf.Check(r, "secret", nil); (err == nil) != c.ok {
+			t.Errorf("Check of %+v returned %v", c, err)
+		}
+	}
+}
@@ -3,62 +3,142 @@ package ghttp
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/geeksteam/GoTools/logger"
-	"github.com/geeksteam/GoTools/shutdown"
 	"github.com/geeksteam/GoTools/stringutils"
-	"github.com/geeksteam/SHM-Backend/core/users"
 	"github.com/geeksteam/SHM-Backend/panicerr"
-	"github.com/geeksteam/SHM-Backend/plugins"
 	"github.com/geeksteam/ghttp/bruteforce"
+	"github.com/geeksteam/ghttp/clock"
+	"github.com/geeksteam/ghttp/cors"
+	"github.com/geeksteam/ghttp/csrf"
+	"github.com/geeksteam/ghttp/hmacauth"
+	"github.com/geeksteam/ghttp/ipfilter"
 	"github.com/geeksteam/ghttp/journal"
-	"github.com/geeksteam/ghttp/moduleutils"
+	"github.com/geeksteam/ghttp/ratelimit"
+	"github.com/geeksteam/ghttp/roles"
+	"github.com/geeksteam/ghttp/secheaders"
 	"github.com/geeksteam/ghttp/sessions"
-	"github.com/getsentry/raven-go"
 	"github.com/gorilla/mux"
 )
 
 var (
 	// cfg = config.Get().GHttp
 	cfg Config
+
+	// configSignatures verifies signed requests for routers without own
+	// Authenticator, nil if HMACAuth is disabled.
+	configSignatures *hmacauth.Authenticator
+	// configCORS is a default CORS policy of routers without own one, nil if
+	// no origins are allowed.
+	configCORS *cors.Policy
 )
 
 // SetConfig Global config settings
 func SetConfig(c Config) {
 	cfg = c
 	bruteforce.SetConfig(bruteforce.BruteForce{
-		BlockAttempts: cfg.BruteForce.BlockAttempts,
-		BanTime:       cfg.BruteForce.BanTime,
-		DataEncoding:  cfg.BruteForce.DataEncoding,
-	})
-	journal.SetConfig(journal.Journal{
-		BoltDB:              cfg.Journal.BoltDB,
-		BucketForOperations: cfg.Journal.BucketForOperations,
-		Capacity:            cfg.Journal.Capacity,
-		DataEncoding:        cfg.Journal.DataEncoding,
+		BlockAttempts:     cfg.BruteForce.BlockAttempts,
+		BanTime:           cfg.BruteForce.BanTime,
+		Window:            cfg.BruteForce.Window,
+		MaxBanTime:        cfg.BruteForce.MaxBanTime,
+		BanDecay:          cfg.BruteForce.BanDecay,
+		PermanentBanAfter: cfg.BruteForce.PermanentBanAfter,
+		BoltDBBruteForce:  cfg.BruteForce.BoltDBBruteForce,
+		BucketForIPs:      cfg.BruteForce.BucketForIPs,
+		BucketForLogins:   cfg.BruteForce.BucketForLogins,
+		FlushInterval:     cfg.BruteForce.FlushInterval,
+		CleanInterval:     cfg.BruteForce.CleanInterval,
+		BanLog:            cfg.BruteForce.BanLog,
+		DataEncoding:      cfg.BruteForce.DataEncoding,
+		Timeouts:          cfg.BruteForce.Timeouts,
+
+		LoginDelayAttempts:    cfg.BruteForce.LoginDelayAttempts,
+		LoginDelay:            cfg.BruteForce.LoginDelay,
+		LoginMaxDelay:         cfg.BruteForce.LoginMaxDelay,
+		LoginUserLockAttempts: cfg.BruteForce.LoginUserLockAttempts,
+		LoginIPLockAttempts:   cfg.BruteForce.LoginIPLockAttempts,
+		LoginLockTime:         cfg.BruteForce.LoginLockTime,
 	})
+	journal.SetConfig(cfg.Journal)
+	ratelimit.SetConfig(cfg.RateLimit)
+	csrf.SetConfig(cfg.CSRF)
+	secheaders.SetConfig(cfg.SecurityHeaders)
+	if err := ipfilter.SetConfig(cfg.IPFilter); err != nil {
+		logger.Error(err.Error())
+	}
 	sessions.SetConfig(sessions.SessionsConf{
-		SessionIDKey:       cfg.SessionsConf.SessionIDKey,
-		SessionIDKeyLength: cfg.SessionsConf.SessionIDKeyLength,
-		SessionLifeTime:    cfg.SessionsConf.SessionLifeTime,
-		StrictIP:           cfg.SessionsConf.StrictIP,
+		SessionIDKey:          cfg.SessionsConf.SessionIDKey,
+		SessionIDKeyLength:    cfg.SessionsConf.SessionIDKeyLength,
+		SessionLifeTime:       cfg.SessionsConf.SessionLifeTime,
+		ImpersonationLifeTime: cfg.SessionsConf.ImpersonationLifeTime,
+		StrictIP:              cfg.SessionsConf.StrictIP,
 	})
+
+	configCORS = nil
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		configCORS = cors.New(cfg.CORS)
+	}
+	configSignatures = nil
+	if cfg.HMACAuth.Enabled {
+		configSignatures = hmacauth.New(cfg.HMACAuth)
+	}
 }
 
-// NewRouter constructs Router instances
-func NewRouter() *Router {
+// NewRouter constructs Router instances. Without options router uses
+// bruteforce.Default and SHM-Backend users, plugins, Sentry and shutdown watcher.
+// Default providers read config on use, so SetConfig may be called after it.
+func NewRouter(options ...Option) *Router {
+	router := &Router{
+		curID:        0,
+		handlers:     map[uint64]rhandler{},
+		Guard:        bruteforce.Default,
+		Users:        shmUsers{},
+		Roles:        configRoles{},
+		SecondFactor: configSecondFactor{},
+		Tokens:       configTokens{},
+		PostHandler:  shmPlugins{},
+		Errors:       sentryReporter{},
+		Lifecycle:    defaultWatcher,
+		clock:        clock.Real,
+		mutex:        sync.RWMutex{},
+		Router:       *mux.NewRouter(),
+	}
+	for _, option := range options {
+		option(router)
+	}
+
 	sessions.NewSessions()
-	return &Router{
-		curID:    0,
-		handlers: map[uint64]rhandler{},
-		mutex:    sync.RWMutex{},
-		Router:   *mux.NewRouter(),
+	if err := router.Guard.Load(); err != nil {
+		logger.Error("Can't load bruteforce state: " + err.Error())
 	}
+	return router
+}
+
+// NewRouterWithGuard constructs Router instances protected by given Guard,
+// so several routers in one process may have separate bans.
+func NewRouterWithGuard(guard *bruteforce.Guard, options ...Option) *Router {
+	return NewRouter(append([]Option{WithGuard(guard)}, options...)...)
+}
+
+// Close saves Guard's state and writes buffered journal operations. Should be
+// called on graceful stop after server has finished handlers.
+func (router *Router) Close() error {
+	err := router.Guard.Close()
+	if errJournal := journal.Close(); err == nil {
+		err = errJournal
+	}
+	return err
+}
+
+// SetClock sets clock used by router for handlers start time and journal
+// dates. Sessions, Guard and journal have own clocks.
+func (r *Router) SetClock(c clock.Clock) {
+	r.clock = c
 }
 
 // Handlers returns copy of an internal Router's handlers list.
@@ -82,15 +162,19 @@ func (r *Router) deleteHandler(id uint64) {
 
 // HandleInternalFunc is a gorilla Router's wrapper function.
 // This handles standart modules functions.
-func (router *Router) HandleInternalFunc(path string, f func(http.ResponseWriter, *http.Request, *sessions.Sessions)) *mux.Route {
+func (router *Router) HandleInternalFunc(path string, f func(http.ResponseWriter, *http.Request, *sessions.Sessions)) *Route {
+	var route *Route
 	routerFunc := func(w http.ResponseWriter, r *http.Request) {
+		// Cross-origin clients should be able to read errors too
+		route.corsPolicy().SetHeaders(w, r)
+
 		// Trigger starting of new process
 		if !isIgnored(r.RequestURI) {
-			if err := shutdown.DefaultWatcher.Start(); err != nil {
+			if err := router.Lifecycle.Start(); err != nil {
 				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
 				return
 			}
-			defer shutdown.DefaultWatcher.Finish()
+			defer router.Lifecycle.Finish()
 		}
 
 		/*
@@ -104,6 +188,7 @@ func (router *Router) HandleInternalFunc(path string, f func(http.ResponseWriter
 
 		// 3. Set headers
 		setHeaderNoCache(w)
+		r = secheaders.Apply(w, r, secheaders.API)
 
 		// 4. Defered run and catch panics
 		defer func() {
@@ -117,15 +202,15 @@ func (router *Router) HandleInternalFunc(path string, f func(http.ResponseWriter
 				switch rec := rec.(type) {
 				// Panicerr catched
 				case panicerr.Error:
-					logger.Info(fmt.Sprintf("%v [ %v ] Error catched: Code '%v' Text '%v'", strings.Split(r.RemoteAddr, ":")[0], r.RequestURI, rec.Code, rec.Err))
+					logger.Info(fmt.Sprintf("%v [ %v ] Error catched: Code '%v' Text '%v'", clientIP(r), r.RequestURI, rec.Code, rec.Err))
 					//Send response with json error description
 					w.Header().Set("Content-Type", "application/json")
 					w.WriteHeader(500)
 					w.Write([]byte(rec.ToJSONString()))
 				// Unknown panic
 				default:
-					raven.CaptureError(fmt.Errorf("%v", rec), nil)
-					logger.Error(fmt.Sprintf("%v [ %v ] Unknown Error catched: '%v'", strings.Split(r.RemoteAddr, ":")[0], r.RequestURI, rec))
+					router.Errors.CaptureError(fmt.Errorf("%v", rec))
+					logger.Error(fmt.Sprintf("%v [ %v ] Unknown Error catched: '%v'", clientIP(r), r.RequestURI, rec))
 					http.Error(w, http.StatusText(500), 500)
 					panic(rec)
 				}
@@ -135,51 +220,102 @@ func (router *Router) HandleInternalFunc(path string, f func(http.ResponseWriter
 		/*
 			# Permissions checks
 		*/
-		// 1. Prevent bruteforce of sessionID
-		ok, duration := bruteforce.Check(strings.Split(r.RemoteAddr, ":")[0])
+		// 1. Refuse denied networks
+		if ipfilter.IsDenied(clientIP(r)) {
+			logger.Warning(fmt.Sprintf("%v [ %v ] Refused by denylist", clientIP(r), r.RequestURI))
+			http.Error(w, http.StatusText(403), 403)
+			return
+		}
+
+		// 2. Prevent bruteforce of sessionID
+		ok, duration := router.Guard.Check(clientIP(r))
 		if !ok {
-			logger.Warning("IP " + strings.Split(r.RemoteAddr, ":")[0] + " banned by bruteforce (no session) for " + strconv.FormatInt(duration, 10) + " sec.")
+			if duration == bruteforce.PermanentBan {
+				logger.Warning("IP " + clientIP(r) + " banned by bruteforce (no session) permanently.")
+			} else {
+				logger.Warning("IP " + clientIP(r) + " banned by bruteforce (no session) for " + strconv.FormatInt(duration, 10) + " sec.")
+				w.Header().Set("Retry-After", strconv.FormatInt(duration, 10))
+			}
 			http.Error(w, http.StatusText(429), 429)
 			return
 		}
 
-		// 2. Check if session started and getting session info
-		sess, err := sessions.SessionsStorage.Get(r)
+		// 3. Check if session started and getting session info, API tokens
+		// get pseudo-session which handlers find with Sessions.Get
+		sess, err := router.session(r)
 		if err != nil {
 			logger.Warning(err.Error())
 			http.Error(w, http.StatusText(401), 401)
 			return
 		}
+		if sess.Auth != "" {
+			r = r.WithContext(sessions.NewContext(r.Context(), sess))
+		}
+
+		// Session waits for second factor, only HandleSecondFactor accepts it
+		if sess.SecondFactorPending {
+			authRequired(w, AuthSecondFactor)
+			return
+		}
 
-		// 3. Clear IP in bruteforce check
-		bruteforce.Clean(strings.Split(r.RemoteAddr, ":")[0])
+		// 4. Clear IP in bruteforce check
+		router.Guard.Clean(clientIP(r))
+
+		// Cookie sessions get CSRF token and should send it back with
+		// state-changing requests, other authentications aren't sent by browsers
+		if sess.Auth == "" && csrf.Enabled() {
+			w.Header().Set(csrf.HeaderName(), sess.CSRFToken)
+			if !csrf.Safe(r.Method) {
+				if err := csrf.Check(r, sess.CSRFToken, route.corsPolicy().AllowsExplicitOrigin); err != nil {
+					logger.Warning(fmt.Sprintf("%v [ %v ] %v as user %v", clientIP(r), r.RequestURI, err.Error(), sess.Username))
+					http.Error(w, http.StatusText(403), 403)
+					return
+				}
+			}
+		}
 
-		// 4. Check for timeout before actions for particular handlers
-		if err := bruteforce.CheckTimeout(r, sessions.SessionsStorage); err != nil {
+		// 5. Check rate limits, allowed networks bypass them
+		limit := ratelimit.Result{Allowed: true, Limit: -1}
+		if !ipfilter.IsAllowed(clientIP(r)) {
+			limit = ratelimit.Allow(ratelimit.Key{
+				IP:        clientIP(r),
+				Username:  sess.Username,
+				SessionID: sess.ID,
+				Route:     path,
+			})
+		}
+		limit.SetHeaders(w)
+		if !limit.Allowed {
+			logger.Warning(fmt.Sprintf("Rate limit exceeded for '%v' by %v as user %v", path, clientIP(r), sess.Username))
 			http.Error(w, http.StatusText(429), 429)
-			log.Println("Timeout error: ", err)
 			return
 		}
 
-		// 5. Register session activity for sessions timeout
-		sessions.SessionsStorage.RegisterActivity(r)
+		// 6. Register session activity for sessions timeout
+		if sess.Auth == "" {
+			sessions.SessionsStorage.RegisterActivity(r)
+		}
 
-		// 6. Check module access permisions
-		if sess.Username != "root" {
-			userInfo := users.Get(sess.Username)
-			if userInfo == nil {
-				panicerr.Core.Auth("Can't get template" + sess.Username)
-			}
+		// 7. Check module access permisions
+		if !router.hasAccess(r, route, sess) {
+			http.Error(w, http.StatusText(403), 403)
+			logger.Warning(fmt.Sprintf("Permission denied to access '%v' for %v as user %v", r.RequestURI, clientIP(r), sess.Username))
+			return
+		}
+		if route.Info().StepUp && !router.recentlyAuthenticated(sess) {
+			authRequired(w, AuthStepUp)
+			return
+		}
 
-			allowedModules := userInfo.GetTemplate().Modules
-			if err != nil || !hasPermissions(r.RequestURI, allowedModules) {
-				http.Error(w, http.StatusText(403), 403)
-				logger.Warning(fmt.Sprintf("Permission denied to access '%v' for %v as user %v", r.RequestURI, strings.Split(r.RemoteAddr, ":")[0], sess.Username))
-				return
-			}
+		// 8. Check for timeout before actions for particular handlers, refused
+		// requests don't start it
+		if err := router.Guard.CheckTimeout(r.Method, path, sess.Username); err != nil {
+			http.Error(w, http.StatusText(429), 429)
+			log.Println("Timeout error: ", err)
+			return
 		}
 
-		// 7. Check for simultaneous connections from a single user
+		// 9. Check for simultaneous connections from a single user
 		router.CheckNumConnection(sess.Username)
 
 		/*
@@ -197,7 +333,7 @@ func (router *Router) HandleInternalFunc(path string, f func(http.ResponseWriter
 				URI:       r.RequestURI,
 				Username:  sess.Username,
 				IP:        r.RemoteAddr,
-				StartTime: time.Now().Format(time.StampMilli),
+				StartTime: router.clock.Now().Format(time.StampMilli),
 				SessionID: sess.ID,
 			}
 			// Append new handler to list
@@ -208,13 +344,15 @@ func (router *Router) HandleInternalFunc(path string, f func(http.ResponseWriter
 			# Add action to journal
 		*/
 
-		journal.Add(journal.Operation{
+		router.addJournal(journal.Operation{
 			SessionID: sess.ID,
-			Date:      time.Now().Format(journal.TimeLayout),
+			Date:      router.clock.Now().Format(journal.TimeLayout),
 			Username:  sess.Username,
-			Operation: moduleutils.GetCurrentModule(r.RequestURI),
+			Operation: route.Info().Module,
 			Content:   r.RequestURI,
 			//Extra:
+			Impersonator: sess.Impersonator,
+			Auth:         sess.Auth,
 		})
 
 		/*
@@ -225,19 +363,34 @@ func (router *Router) HandleInternalFunc(path string, f func(http.ResponseWriter
 		/*
 			# Make api trigger call
 		*/
-		plugins.DefaultManager.Trigger(w, r, sess)
+		router.PostHandler.AfterHandler(w, r, sess)
 	}
 	// Insert func to gorilla/mux router
-	return router.HandleFunc(path, routerFunc)
+	route = router.newRoute(KindInternal, path, router.HandleFunc(path, routerFunc))
+	return route
 }
 
-// HandleLoginFunc is uniq handler for Authorization and create new session only
-func (router *Router) HandleLoginFunc(path string, f func(http.ResponseWriter, *http.Request, *sessions.Sessions)) *mux.Route {
+// HandleLoginFunc is uniq handler for Authorization and create new session only.
+// Handler should protect itself with Guard's LoginCheck, LoginFailed and LoginSucceeded.
+// Handler should start session with Router.StartSession to respect second factor.
+func (router *Router) HandleLoginFunc(path string, f func(http.ResponseWriter, *http.Request, *sessions.Sessions)) *Route {
+	var route *Route
 	routerFunc := func(w http.ResponseWriter, r *http.Request) {
+		route.corsPolicy().SetHeaders(w, r)
+
+		/*
+			Refuse denied networks
+		*/
+		if ipfilter.IsDenied(clientIP(r)) {
+			logger.Warning(fmt.Sprintf("%v [ %v ] Refused by denylist", clientIP(r), r.RequestURI))
+			http.Error(w, http.StatusText(403), 403)
+			return
+		}
 		/*
 			Set headers
 		*/
 		setHeaderNoCache(w)
+		r = secheaders.Apply(w, r, secheaders.API)
 		/*
 			Defered run and catch panics
 		*/
@@ -247,14 +400,14 @@ func (router *Router) HandleLoginFunc(path string, f func(http.ResponseWriter, *
 				switch rec := rec.(type) {
 				// Panicerr catched
 				case panicerr.Error:
-					logger.Info(fmt.Sprintf("%v [ %v ] Error catched: Code '%v' Text '%v'", strings.Split(r.RemoteAddr, ":")[0], r.RequestURI, rec.Code, rec.Err))
+					logger.Info(fmt.Sprintf("%v [ %v ] Error catched: Code '%v' Text '%v'", clientIP(r), r.RequestURI, rec.Code, rec.Err))
 					//Send response with json error description
 					w.WriteHeader(500)
 					w.Write([]byte(rec.ToJSONString()))
 				// Unknown panic
 				default:
-					raven.CaptureError(fmt.Errorf("%v", rec), nil)
-					logger.Error(fmt.Sprintf("%v [ %v ] Unknown Error catched: '%v'", strings.Split(r.RemoteAddr, ":")[0], r.RequestURI, rec))
+					router.Errors.CaptureError(fmt.Errorf("%v", rec))
+					logger.Error(fmt.Sprintf("%v [ %v ] Unknown Error catched: '%v'", clientIP(r), r.RequestURI, rec))
 					http.Error(w, http.StatusText(500), 500)
 					panic(rec)
 				}
@@ -269,10 +422,26 @@ func (router *Router) HandleLoginFunc(path string, f func(http.ResponseWriter, *
 		/*
 			Make api trigger call
 		*/
-		plugins.DefaultManager.Trigger(w, r, nil)
+		router.PostHandler.AfterHandler(w, r, nil)
 	}
 	// Insert func to gorilla/mux router
-	return router.HandleFunc(path, routerFunc)
+	route = router.newRoute(KindLogin, path, router.HandleFunc(path, routerFunc))
+	return route
+}
+
+// Timeout sets timeout in seconds between runs of route's handler for single
+// user. Routes of HandleInternalFunc have Route.Timeout, like
+// router.HandleInternalFunc(...).Methods("POST").Timeout(10).
+// Route should have path template and methods set already.
+func (router *Router) Timeout(route *mux.Route, seconds int64) *mux.Route {
+	pattern, err := route.GetPathTemplate()
+	if err != nil {
+		log.Println("Can't set timeout for route:", err)
+		return route
+	}
+	methods, _ := route.GetMethods()
+	router.Guard.SetTimeout(pattern, seconds, methods...)
+	return route
 }
 
 // CheckNumConnection - Checking for number of simultaneous requests for user
@@ -292,31 +461,68 @@ func (router *Router) CheckNumConnection(username string) {
 	}
 }
 
-// Check for user permissions to module for /uri
-func hasPermissions(path string, modules []string) bool {
-	// If no modules allow access
-	if len(modules) == 0 {
+// hasAccess checks user's roles and template permissions for request to route.
+func (router *Router) hasAccess(r *http.Request, route *Route, sess *sessions.Session) bool {
+	info := route.Info()
+	// API tokens are restricted by scopes even for root
+	if sess.Auth != "" && !info.allowsMethod(sess.Scopes, r.Method) {
+		return false
+	}
+	if info.AnyUser {
 		return true
 	}
-	// Iterate and check for each module in grant list
-	for _, userModule := range modules {
-		if userModule == moduleutils.GetCurrentModule(path) {
-			return true
-		}
+	granted, root := router.userPermissions(sess.Username)
+	return root || info.allowsMethod(granted, r.Method)
+}
+
+// userPermissions returns permissions granted to user by template and roles
+// and whether user has Root role, which grants everything. Users with roles
+// may have no template.
+func (router *Router) userPermissions(username string) ([]string, bool) {
+	userRoles, err := router.Roles.UserRoles(username)
+	if err != nil {
+		panicerr.Core.Auth(err.Error())
+	}
+	if roles.Has(roles.Names(userRoles), roles.Root) {
+		return nil, true
+	}
+
+	granted, err := router.Users.Permissions(username)
+	if err != nil && len(userRoles) == 0 {
+		panicerr.Core.Auth(err.Error())
 	}
-	return false
+	return append(granted, roles.Permissions(userRoles)...), false
 }
 
 // Set http headers to no-cache, content json
 func setHeaderNoCache(w http.ResponseWriter) {
-	w.Header().Set("Server", cfg.WebServerName)
-	w.Header().Set("Version", cfg.Version)
+	if cfg.ShowVersion {
+		w.Header().Set("Server", cfg.WebServerName)
+		w.Header().Set("Version", cfg.Version)
+	}
 	w.Header().Set("Cache-Control", "post-check=0, pre-check=0, no-store, no-cache, must-revalidate, max-age=0")
 	w.Header().Set("Pragma", "no-cache")
 	w.Header().Set("Expires", "-1")
 	w.Header().Set("Content-Type", "application/json")
 }
 
+// addJournal adds operation to journal. Errors are logged only, handler's
+// response doesn't depend on them.
+func (router *Router) addJournal(op journal.Operation) {
+	if err := journal.Add(op); err != nil {
+		logger.Error("Can't add operation to journal: " + err.Error())
+	}
+}
+
+// clientIP returns client IP without port, IPv6 addresses are supported.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func isIgnored(path string) bool {
 	var ignored = []string{
 		"/api/info/actualizer/",
@@ -0,0 +1,556 @@
+package ghttp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geeksteam/ghttp"
+	"github.com/geeksteam/ghttp/cors"
+	"github.com/geeksteam/ghttp/ghttptest"
+	"github.com/geeksteam/ghttp/handlerutils"
+	"github.com/geeksteam/ghttp/hmacauth"
+	"github.com/geeksteam/ghttp/journal"
+	"github.com/geeksteam/ghttp/roles"
+	"github.com/geeksteam/ghttp/sessions"
+	"github.com/geeksteam/ghttp/utemplates"
+)
+
+func TestHandleInternalFunc(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	h.Router.HandleInternalFunc("/api/dns/list", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+		s.Actualize("bob", "dns updated")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// No session
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/list", nil, nil), http.StatusUnauthorized)
+
+	// Module is not allowed
+	alice := h.Login("alice", utemplates.UserTemplate{Modules: []string{"mysql"}})
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/list", nil, alice), http.StatusForbidden)
+
+	bob := h.Login("bob", utemplates.UserTemplate{Modules: []string{"dns"}})
+	h.ListenActualizer(bob)
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/list?x=1", nil, bob), http.StatusOK)
+	h.AssertJournal("bob", "/api/dns/list?x=1")
+	if m := h.ActualizerMessages(bob); len(m) != 1 || m[0] != "dns updated" {
+		t.Errorf("Unexpected Actualizer messages %v", m)
+	}
+}
+
+func TestPermissionsByMethod(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	h.Router.HandleInternalFunc("/api/dns/records", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {})
+
+	reader := h.Login("reader", utemplates.UserTemplate{Permissions: []string{"dns:read"}})
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/records", nil, reader), http.StatusOK)
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/dns/records", nil, reader), http.StatusForbidden)
+
+	nobody := h.Login("nobody", utemplates.UserTemplate{})
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/records", nil, nobody), http.StatusForbidden)
+}
+
+func TestRoles(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	h.Router.HandleInternalFunc("/api/dns/records", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {})
+
+	staff := h.Login("staff", utemplates.UserTemplate{})
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/records", nil, staff), http.StatusForbidden)
+
+	// Role changes apply to live session
+	h.Users.SetRoles("staff", roles.Role{Name: roles.Support, Permissions: []string{"*:read"}})
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/records", nil, staff), http.StatusOK)
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/dns/records", nil, staff), http.StatusForbidden)
+
+	h.Users.SetRoles("staff", roles.Role{Name: roles.Root})
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/dns/records", nil, staff), http.StatusOK)
+}
+
+func TestRequire(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	nop := func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {}
+	h.Router.HandleInternalFunc("/api/mysql/{db}", nop).Require("mysql:write").Methods("GET")
+	h.Router.HandleInternalFunc("/api/dns/list", nop).Methods("GET")
+	h.Router.HandleLoginFunc("/api/login", nop).Methods("POST")
+
+	bob := h.Login("bob", utemplates.UserTemplate{Permissions: []string{"mysql:read", "dns:read"}})
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/mysql/shop", nil, bob), http.StatusForbidden)
+
+	routes := h.Router.RoutesFor("bob")
+	if len(routes) != 2 || routes[0].Path != "/api/dns/list" || routes[1].Kind != ghttp.KindLogin {
+		t.Errorf("Unexpected routes for bob: %+v", routes)
+	}
+
+	all := h.Router.Routes()
+	if len(all) != 3 || all[2].Module != "mysql" || all[2].Permissions[0] != "mysql:write" {
+		t.Errorf("Unexpected routes: %+v", all)
+	}
+}
+
+func TestBruteforceWithoutSession(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	h.Router.HandleInternalFunc("/api/dns/list", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {})
+
+	for i := 0; i < h.Config.BruteForce.BlockAttempts; i++ {
+		ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/list", nil, nil), http.StatusUnauthorized)
+	}
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/list", nil, nil), http.StatusTooManyRequests)
+	h.AssertBanned(ghttptest.DefaultIP, true)
+}
+
+func TestImpersonation(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	h.Router.HandleInternalFunc("/api/users/impersonate", h.Router.HandleStartImpersonation).Require("users:impersonate").Methods("POST")
+	h.Router.HandleInternalFunc("/api/users/return", h.Router.HandleStopImpersonation).AnyUser().Methods("POST")
+	h.Router.HandleInternalFunc("/api/dns/list", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {})
+
+	h.Router.HandleInternalFunc("/api/users/2fa/enroll", h.Router.HandleEnroll).AnyUser().Methods("POST")
+	h.Router.HandleInternalFunc("/api/users/2fa/disable", h.Router.HandleDisable).AnyUser().StepUp().Methods("POST")
+
+	h.Users.Set("bob", utemplates.UserTemplate{Permissions: []string{"dns:read"}})
+	h.Users.SetRoles("carol", roles.Role{Name: roles.Admin, Permissions: []string{"*"}})
+	admin := h.Login("admin", utemplates.UserTemplate{Permissions: []string{"users:impersonate", "dns:*"}})
+
+	// Impersonation can't grant permissions caller doesn't have
+	support := h.Login("support", utemplates.UserTemplate{Permissions: []string{"users:impersonate"}})
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/users/impersonate", strings.NewReader(`{"Username":"carol"}`), support), http.StatusInternalServerError)
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/users/impersonate", strings.NewReader(`{"Username":"bob"}`), support), http.StatusInternalServerError)
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/users/impersonate", strings.NewReader(`{"Username":"carol"}`), admin), http.StatusInternalServerError)
+
+	w := h.Request("POST", "/api/users/impersonate", strings.NewReader(`{"Username":"bob"}`), admin)
+	ghttptest.AssertStatus(t, w, http.StatusOK)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Unexpected cookies %v", cookies)
+	}
+	bob := &sessions.Session{ID: cookies[0].Value}
+
+	w = h.Request("GET", "/api/dns/list", nil, bob)
+	ghttptest.AssertStatus(t, w, http.StatusOK)
+	bob.CSRFToken = w.Header().Get(h.Config.CSRF.HeaderName)
+	found := false
+	for _, op := range h.Journal() {
+		if op.Content == "/api/dns/list" {
+			found = op.Username == "bob" && op.Impersonator == "admin"
+		}
+	}
+	if !found {
+		t.Errorf("No journal operation by bob impersonated by admin: %+v", h.Journal())
+	}
+
+	// Admin can't pass step-up or change second factor as user
+	w = h.Request("POST", "/api/users/2fa/disable", nil, bob)
+	ghttptest.AssertStatus(t, w, http.StatusUnauthorized)
+	if w.Header().Get(ghttp.AuthRequiredHeader) != ghttp.AuthStepUp {
+		t.Errorf("Unexpected %v header %v", ghttp.AuthRequiredHeader, w.Header().Get(ghttp.AuthRequiredHeader))
+	}
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/users/2fa/enroll", nil, bob), http.StatusInternalServerError)
+
+	// Impersonated user can't impersonate further but can return
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/users/impersonate", strings.NewReader(`{"Username":"bob"}`), bob), http.StatusForbidden)
+	w = h.Request("POST", "/api/users/return", nil, bob)
+	ghttptest.AssertStatus(t, w, http.StatusOK)
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].Value != admin.ID {
+		t.Errorf("Unexpected cookies after return %v", c)
+	}
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/list", nil, bob), http.StatusUnauthorized)
+}
+
+func TestImpersonationLifeTime(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	h.Router.HandleInternalFunc("/api/dns/list", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {})
+	h.Users.Set("bob", utemplates.UserTemplate{Permissions: []string{"dns:read"}})
+	admin := h.Login("admin", utemplates.UserTemplate{})
+
+	r := h.NewRequest("POST", "/api/users/impersonate", nil, admin)
+	bob, err := sessions.SessionsStorage.StartImpersonation(r, httptest.NewRecorder(), admin, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step := time.Duration(h.Config.SessionsConf.ImpersonationLifeTime/3) * time.Second
+	for i := 0; i < 2; i++ {
+		h.Clock.Add(step)
+		ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/list", nil, bob), http.StatusOK)
+	}
+	h.Clock.Add(step)
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/list", nil, bob), http.StatusUnauthorized)
+}
+
+func TestSecondFactor(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	nop := func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {}
+	h.Router.HandleLoginFunc("/api/login/code", h.Router.HandleSecondFactor).Methods("POST")
+	h.Router.HandleInternalFunc("/api/login/stepup", h.Router.HandleStepUp).AnyUser().Methods("POST")
+	h.Router.HandleInternalFunc("/api/dns/list", nop)
+	h.Router.HandleInternalFunc("/api/users/delete", nop).StepUp()
+
+	h.Users.Set("bob", utemplates.UserTemplate{Permissions: []string{"dns:read", "users:*"}})
+	if _, _, err := h.SecondFactor.Enroll("bob"); err != nil {
+		t.Fatal(err)
+	}
+	code, _ := h.SecondFactor.Code("bob", h.Clock.Now())
+	recovery, err := h.SecondFactor.Confirm("bob", code, h.Clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := h.NewRequest("POST", "/api/login", nil, nil)
+	bob := h.Router.StartSession(r, httptest.NewRecorder(), "bob")
+
+	w := h.Request("GET", "/api/dns/list", nil, bob)
+	ghttptest.AssertStatus(t, w, http.StatusUnauthorized)
+	if w.Header().Get(ghttp.AuthRequiredHeader) != ghttp.AuthSecondFactor {
+		t.Errorf("Unexpected %v header %v", ghttp.AuthRequiredHeader, w.Header().Get(ghttp.AuthRequiredHeader))
+	}
+
+	// Confirmation code can't be reused
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/login/code", strings.NewReader(`{"Code":"`+code+`"}`), bob), http.StatusUnauthorized)
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/login/code", strings.NewReader(`{"Code":"`+recovery[0]+`"}`), bob), http.StatusNoContent)
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/list", nil, bob), http.StatusOK)
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/users/delete", nil, bob), http.StatusOK)
+
+	h.Clock.Add(time.Duration(h.Config.TwoFactor.StepUpTime+1) * time.Second)
+	w = h.Request("GET", "/api/users/delete", nil, bob)
+	ghttptest.AssertStatus(t, w, http.StatusUnauthorized)
+	if w.Header().Get(ghttp.AuthRequiredHeader) != ghttp.AuthStepUp {
+		t.Errorf("Unexpected %v header %v", ghttp.AuthRequiredHeader, w.Header().Get(ghttp.AuthRequiredHeader))
+	}
+
+	// Recovery code is used already
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/login/stepup", strings.NewReader(`{"Code":"`+recovery[0]+`"}`), bob), http.StatusUnauthorized)
+	code, _ = h.SecondFactor.Code("bob", h.Clock.Now())
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/login/stepup", strings.NewReader(`{"Code":"`+code+`"}`), bob), http.StatusNoContent)
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/users/delete", nil, bob), http.StatusOK)
+}
+
+func TestTokens(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	h.Router.HandleInternalFunc("/api/dns/records", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+		sess, err := s.Get(r)
+		if err != nil || sess.Username != "bob" {
+			t.Errorf("Handler can't get token session: %v %v", sess, err)
+		}
+	})
+	h.Router.HandleInternalFunc("/api/tokens/create", h.Router.HandleCreateToken).AnyUser().Methods("POST")
+
+	h.Users.Set("bob", utemplates.UserTemplate{Permissions: []string{"dns:read", "tokens:write"}})
+	raw, _, err := h.Tokens.Create("bob", "script", []string{"dns:*", "tokens:*"}, 0, []string{ghttptest.DefaultIP}, h.Clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Scopes don't extend user's permissions
+	ghttptest.AssertStatus(t, h.Do(h.NewTokenRequest("GET", "/api/dns/records", nil, raw)), http.StatusOK)
+	ghttptest.AssertStatus(t, h.Do(h.NewTokenRequest("POST", "/api/dns/records", nil, raw)), http.StatusForbidden)
+	ghttptest.AssertStatus(t, h.Do(h.NewTokenRequest("GET", "/api/dns/records", nil, raw+"x")), http.StatusUnauthorized)
+
+	found := false
+	for _, op := range h.Journal() {
+		found = found || (op.Username == "bob" && op.Auth == sessions.AuthToken)
+	}
+	if !found {
+		t.Errorf("No token-authenticated operation in journal: %+v", h.Journal())
+	}
+
+	// Tokens can't create tokens
+	w := h.Do(h.NewTokenRequest("POST", "/api/tokens/create", strings.NewReader(`{"Name":"x","Scopes":["dns:read"]}`), raw))
+	ghttptest.AssertStatus(t, w, http.StatusInternalServerError)
+
+	// Neither can admins impersonating user
+	admin := h.Login("admin", utemplates.UserTemplate{})
+	bob, err := sessions.SessionsStorage.StartImpersonation(h.NewRequest("POST", "/", nil, admin), httptest.NewRecorder(), admin, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = h.Request("POST", "/api/tokens/create", strings.NewReader(`{"Name":"x","Scopes":["dns:read"]}`), bob)
+	ghttptest.AssertStatus(t, w, http.StatusInternalServerError)
+	if list, _ := h.Tokens.List("bob"); len(list) != 1 || list[0].Hash != "" {
+		t.Errorf("Unexpected tokens of bob %+v", list)
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	h.Router.Signatures = hmacauth.New(hmacauth.HMACAuth{
+		MaxSkew:     300,
+		MaxBodySize: 1024,
+		Clients: map[string]hmacauth.Client{
+			"billing": {Secret: "s3cret", Username: "billing", Scopes: []string{"billing:*"}},
+		},
+	})
+	h.Router.HandleInternalFunc("/api/billing/pay", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+		handlerUtils.SendOkStatus(w)
+	})
+	h.Users.Set("billing", utemplates.UserTemplate{Permissions: []string{"billing:write", "dns:write"}})
+
+	r := h.NewRequest("POST", "/api/billing/pay", strings.NewReader(`{"Sum":10}`), nil)
+	hmacauth.Sign(r, "billing", "s3cret", h.Clock.Now())
+	replay := h.NewRequest("POST", "/api/billing/pay", strings.NewReader(`{"Sum":10}`), nil)
+	replay.Header = r.Header
+
+	ghttptest.AssertStatus(t, h.Do(r), http.StatusNoContent)
+	ghttptest.AssertStatus(t, h.Do(replay), http.StatusUnauthorized)
+
+	found := false
+	for _, op := range h.Journal() {
+		found = found || (op.Username == "billing" && op.Auth == sessions.AuthHMAC)
+	}
+	if !found {
+		t.Errorf("No signed operation in journal: %+v", h.Journal())
+	}
+
+	// Scopes restrict user's permissions
+	h.Router.HandleInternalFunc("/api/dns/records", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {})
+	r = h.NewRequest("POST", "/api/dns/records", nil, nil)
+	hmacauth.Sign(r, "billing", "s3cret", h.Clock.Now())
+	ghttptest.AssertStatus(t, h.Do(r), http.StatusForbidden)
+}
+
+func TestCSRF(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	h.Router.HandleInternalFunc("/api/dns/records", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {})
+	bob := h.Login("bob", utemplates.UserTemplate{Permissions: []string{"dns:*"}})
+
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/dns/records", nil, bob), http.StatusOK)
+
+	r := h.NewRequest("POST", "/api/dns/records", nil, bob)
+	r.Header.Del(h.Config.CSRF.HeaderName)
+	ghttptest.AssertStatus(t, h.Do(r), http.StatusForbidden)
+
+	r = h.NewRequest("POST", "/api/dns/records", nil, bob)
+	r.Header.Set("Origin", "https://evil.example.org")
+	ghttptest.AssertStatus(t, h.Do(r), http.StatusForbidden)
+
+	// Safe methods need no token
+	r = h.NewRequest("GET", "/api/dns/records", nil, bob)
+	r.Header.Del(h.Config.CSRF.HeaderName)
+	ghttptest.AssertStatus(t, h.Do(r), http.StatusOK)
+
+	// API tokens aren't sent by browsers
+	raw, _, _ := h.Tokens.Create("bob", "script", []string{"dns:*"}, 0, nil, h.Clock.Now())
+	r = h.NewTokenRequest("POST", "/api/dns/records", nil, raw)
+	r.Header.Set("Origin", "https://evil.example.org")
+	ghttptest.AssertStatus(t, h.Do(r), http.StatusOK)
+}
+
+func TestCORS(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	h.Router.CORS = cors.New(cors.CORS{
+		AllowedOrigins:   []string{"https://panel.example.com"},
+		AllowedMethods:   "GET, POST",
+		AllowedHeaders:   "Content-Type, X-CSRF-Token",
+		ExposedHeaders:   "X-CSRF-Token",
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+	nop := func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {}
+	h.Router.HandleInternalFunc("/api/dns/records", nop).Methods("POST")
+	h.Router.HandleInternalFunc("/api/billing/pay", nop).
+		CORS(cors.New(cors.CORS{AllowedOrigins: []string{"https://billing.example.com"}, AllowedMethods: "POST"})).
+		Methods("POST")
+	h.Router.HandleInternalFunc("/api/dns/public", nop).
+		Methods("POST").
+		CORS(cors.New(cors.CORS{AllowedOrigins: []string{"*"}, AllowedMethods: "POST"}))
+
+	preflight := func(path, origin string) *httptest.ResponseRecorder {
+		r := h.NewRequest("OPTIONS", path, nil, nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		return h.Do(r)
+	}
+
+	// Preflights are answered before bruteforce checks
+	for i := 0; i <= h.Config.BruteForce.BlockAttempts; i++ {
+		w := preflight("/api/dns/records", "https://panel.example.com")
+		ghttptest.AssertStatus(t, w, http.StatusNoContent)
+		if w.Header().Get("Access-Control-Allow-Origin") != "https://panel.example.com" {
+			t.Fatalf("Unexpected preflight headers %v", w.Header())
+		}
+	}
+	h.AssertBanned(ghttptest.DefaultIP, false)
+
+	if w := preflight("/api/billing/pay", "https://panel.example.com"); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Route policy should refuse router's origin: %v", w.Header())
+	}
+	if w := preflight("/api/billing/pay", "https://billing.example.com"); w.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Errorf("Route policy should allow own origin: %v", w.Header())
+	}
+
+	// Allowed origin passes CSRF Origin check and reads headers
+	bob := h.Login("bob", utemplates.UserTemplate{Permissions: []string{"dns:*"}})
+	r := h.NewRequest("POST", "/api/dns/records", nil, bob)
+	r.Header.Set("Origin", "https://panel.example.com")
+	w := h.Do(r)
+	ghttptest.AssertStatus(t, w, http.StatusOK)
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" || w.Header().Get("Access-Control-Expose-Headers") != "X-CSRF-Token" {
+		t.Errorf("Unexpected CORS headers %v", w.Header())
+	}
+
+	// * policy doesn't disable CSRF Origin check
+	r = h.NewRequest("POST", "/api/dns/public", nil, bob)
+	r.Header.Set("Origin", "https://evil.example.net")
+	ghttptest.AssertStatus(t, h.Do(r), http.StatusForbidden)
+}
+
+func TestTimeoutAfterChecks(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	nop := func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {}
+	h.Router.HandleInternalFunc("/api/users/delete", nop).Methods("POST").StepUp().Timeout(60)
+
+	bob := h.Login("bob", utemplates.UserTemplate{Permissions: []string{"users:write"}})
+	alice := h.Login("alice", utemplates.UserTemplate{})
+
+	// Refused requests don't start timeout
+	h.Clock.Add(time.Duration(h.Config.TwoFactor.StepUpTime+1) * time.Second)
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/users/delete", nil, alice), http.StatusForbidden)
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/users/delete", nil, bob), http.StatusUnauthorized)
+	if err := sessions.SessionsStorage.Reauthenticated(h.NewRequest("POST", "/", nil, bob)); err != nil {
+		t.Fatal(err)
+	}
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/users/delete", nil, bob), http.StatusOK)
+	ghttptest.AssertStatus(t, h.Request("POST", "/api/users/delete", nil, bob), http.StatusTooManyRequests)
+}
+
+func TestAsyncJournal(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	// Journal config is passed through whole
+	c := h.Config
+	c.Journal.Async = true
+	c.Journal.BufferSize = 10
+	c.Journal.BatchSize = 10
+	c.Journal.FlushInterval = 3600
+	ghttp.SetConfig(c)
+
+	h.Router.HandleInternalFunc("/api/dns/list", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {})
+	bob := h.Login("bob", utemplates.UserTemplate{Permissions: []string{"dns:read"}})
+	ghttptest.AssertStatus(t, h.Request("GET", "/api/dns/list", nil, bob), http.StatusOK)
+
+	if n := journal.Pending(); n != 1 {
+		t.Errorf("%v operations buffered, expected 1", n)
+	}
+	h.AssertJournal("bob", "/api/dns/list")
+	if n := journal.Pending(); n != 0 {
+		t.Errorf("%v operations buffered after flush, expected 0", n)
+	}
+}
+
+func TestConfigAfterNewRouter(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+	router := ghttp.NewRouterWithGuard(h.Guard)
+
+	dir, err := ioutil.TempDir("", "ghttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Default providers read config set after NewRouter
+	c := h.Config
+	c.TwoFactor.BoltDBTwoFactor = filepath.Join(dir, "main.db")
+	c.TwoFactor.BoltDBTwoFactorBucket = "TwoFactor"
+	c.TwoFactor.DataEncoding = "json"
+	c.Tokens.BoltDBTokens = filepath.Join(dir, "main.db")
+	c.Tokens.BoltDBTokensBucket = "APITokens"
+	c.Tokens.DataEncoding = "json"
+	c.HMACAuth = hmacauth.HMACAuth{
+		Enabled:     true,
+		MaxSkew:     300,
+		MaxBodySize: 1024,
+		Clients: map[string]hmacauth.Client{
+			"billing": {Secret: "s3cret", Username: "billing", Scopes: []string{"billing:*"}},
+		},
+	}
+	c.CORS = cors.CORS{AllowedOrigins: []string{"https://panel.example.com"}, AllowedMethods: "POST"}
+	ghttp.SetConfig(c)
+
+	if _, _, err := router.SecondFactor.Enroll("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := router.SecondFactor.Enabled("bob"); err != nil || enabled {
+		t.Errorf("Enabled before confirmation: %v, %v", enabled, err)
+	}
+
+	raw, _, err := router.Tokens.Create("bob", "ci", []string{"dns:read"}, 0, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := router.Tokens.Authenticate(raw, "127.0.0.1", time.Now()); err != nil || token.Username != "bob" {
+		t.Errorf("Token of bob: %v, %v", token, err)
+	}
+
+	h.Router.HandleInternalFunc("/api/billing/pay", func(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+		handlerUtils.SendOkStatus(w)
+	})
+	h.Users.Set("billing", utemplates.UserTemplate{Permissions: []string{"billing:write"}})
+	r := h.NewRequest("POST", "/api/billing/pay", nil, nil)
+	hmacauth.Sign(r, "billing", "s3cret", h.Clock.Now())
+	ghttptest.AssertStatus(t, h.Do(r), http.StatusNoContent)
+
+	preflight := h.NewRequest("OPTIONS", "/api/billing/pay", nil, nil)
+	preflight.Header.Set("Origin", "https://panel.example.com")
+	preflight.Header.Set("Access-Control-Request-Method", "POST")
+	w := h.Do(preflight)
+	ghttptest.AssertStatus(t, w, http.StatusNoContent)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://panel.example.com" {
+		t.Errorf("Unexpected preflight headers %v", w.Header())
+	}
+}
+
+func TestBanJournal(t *testing.T) {
+	h := ghttptest.New(t)
+	defer h.Close()
+
+	if err := h.Guard.BanAs("10.0.0.9", 60, "test", "admin", "sid"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Guard.UnbanAs("10.0.0.9", "admin", "sid"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bans are journaled under admin who made them with banned IP in Extra
+	count := 0
+	for _, op := range h.Journal() {
+		if op.Operation == "bruteforce" && op.Username == "admin" && op.Extra == "ip=10.0.0.9" && op.SessionID == "sid" {
+			count++
+		}
+	}
+	if count != 2 {
+		t.Errorf("%v ban operations by admin in journal, expected 2: %+v", count, h.Journal())
+	}
+}
@@ -0,0 +1,38 @@
+package ghttptest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock.Clock which stands still until moved by Add or Set.
+type FakeClock struct {
+	now   time.Time
+	mutex sync.RWMutex
+}
+
+// NewFakeClock is a FakeClock constructor.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns fake current time.
+func (c *FakeClock) Now() time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.now
+}
+
+// Add moves clock forward by d.
+func (c *FakeClock) Add(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set sets fake current time.
+func (c *FakeClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now
+}
@@ -0,0 +1,314 @@
+// Package ghttptest builds ghttp routers with in-memory stores and fake clock,
+// so handlers can be tested behind the full pipeline: bruteforce, sessions,
+// permissions and journal.
+//
+// Journal is kept in BoltDB in temporary directory, and Harness sets package
+// level state of ghttp and its packages: config of journal, sessions, csrf and
+// secheaders, sessions storage and clocks. Harnesses are not parallel-safe,
+// tests using them must not call t.Parallel().
+package ghttptest
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geeksteam/ghttp"
+	"github.com/geeksteam/ghttp/bruteforce"
+	"github.com/geeksteam/ghttp/csrf"
+	"github.com/geeksteam/ghttp/journal"
+	"github.com/geeksteam/ghttp/ratelimit"
+	"github.com/geeksteam/ghttp/roles"
+	"github.com/geeksteam/ghttp/sessions"
+	"github.com/geeksteam/ghttp/twofactor"
+	"github.com/geeksteam/ghttp/utemplates"
+)
+
+// DefaultIP is a client IP of requests made by Harness.
+const DefaultIP = "192.0.2.1"
+
+// Harness is a test router with its stores.
+type Harness struct {
+	Router *ghttp.Router
+	Guard  *bruteforce.Guard
+	Clock  *FakeClock
+	Users  *Users
+	Config ghttp.Config
+
+	SecondFactor *SecondFactor
+	Tokens       *Tokens
+
+	t      testing.TB
+	dir    string
+	errors []error
+	mutex  sync.Mutex
+}
+
+// New constructs Harness with test config. Journal is kept in temporary
+// directory, which is removed on Close. Only one harness may be used at a
+// time, see package doc.
+func New(t testing.TB) *Harness {
+	dir, err := ioutil.TempDir("", "ghttptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := ghttp.Config{
+		MaxHandlersForUser: 30,
+		Version:            "test",
+		WebServerName:      "ghttptest",
+		BruteForce: bruteforce.BruteForce{
+			BlockAttempts: 10,
+			BanTime:       600,
+			Window:        600,
+			DataEncoding:  "json",
+		},
+		Journal: journal.Journal{
+			BoltDB:              filepath.Join(dir, "journal.db"),
+			BucketForOperations: "Operations",
+			Capacity:            60,
+			DataEncoding:        "json",
+		},
+		SessionsConf: sessions.SessionsConf{
+			SessionIDKey:          "sessionID",
+			SessionIDKeyLength:    24,
+			SessionLifeTime:       1800,
+			ImpersonationLifeTime: 900,
+			StrictIP:              true,
+		},
+		RateLimit: ratelimit.RateLimit{Backend: "memory"},
+		CSRF:      csrf.CSRF{Enabled: true, HeaderName: "X-CSRF-Token"},
+		TwoFactor: twofactor.TwoFactor{
+			Issuer:        "ghttptest",
+			Digits:        6,
+			Period:        30,
+			Skew:          1,
+			RecoveryCodes: 10,
+			StepUpTime:    300,
+		},
+	}
+	return NewWithConfig(t, cfg, dir)
+}
+
+// NewWithConfig constructs Harness with given config. Journal's BoltDB should
+// be within dir, which is removed on Close.
+func NewWithConfig(t testing.TB, cfg ghttp.Config, dir string) *Harness {
+	clock := NewFakeClock(time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC))
+	ghttp.SetConfig(cfg)
+	journal.SetClock(clock)
+	ratelimit.SetClock(clock)
+
+	guard := bruteforce.New(cfg.BruteForce)
+	guard.SetClock(clock)
+
+	h := &Harness{
+		Guard:  guard,
+		Clock:  clock,
+		Users:  NewUsers(),
+		Config: cfg,
+		t:      t,
+		dir:    dir,
+
+		SecondFactor: NewSecondFactor(cfg.TwoFactor),
+		Tokens:       NewTokens(),
+	}
+	h.Router = ghttp.NewRouter(
+		ghttp.WithGuard(guard),
+		ghttp.WithUsers(h.Users),
+		ghttp.WithRoles(h.Users),
+		ghttp.WithSecondFactor(h.SecondFactor),
+		ghttp.WithTokens(h.Tokens),
+		ghttp.WithPostHandlerHook(nopHook{}),
+		ghttp.WithErrorReporter(h),
+		ghttp.WithLifecycleWatcher(nopWatcher{}),
+		ghttp.WithClock(clock),
+	)
+	sessions.SessionsStorage.SetClock(clock)
+	return h
+}
+
+// CaptureError records unknown panics of handlers, they are returned by Errors.
+func (h *Harness) CaptureError(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.errors = append(h.errors, err)
+}
+
+// Errors returns unknown panics of handlers caught so far.
+func (h *Harness) Errors() []error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]error{}, h.errors...)
+}
+
+// Close removes harness temporary files.
+func (h *Harness) Close() {
+	h.Guard.Close()
+	journal.Close()
+	os.RemoveAll(h.dir)
+}
+
+// Login registers user with template and starts user's session from DefaultIP.
+func (h *Harness) Login(username string, template utemplates.UserTemplate) *sessions.Session {
+	h.Users.Set(username, template)
+
+	r := httptest.NewRequest("POST", "/api/login", nil)
+	r.RemoteAddr = DefaultIP + ":1234"
+	return sessions.SessionsStorage.StartNewSession(r, httptest.NewRecorder(), username)
+}
+
+// NewRequest creates request from DefaultIP with session cookie and its CSRF
+// token, sess may be nil.
+func (h *Harness) NewRequest(method, target string, body io.Reader, sess *sessions.Session) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	r.RemoteAddr = DefaultIP + ":1234"
+	if sess != nil {
+		r.AddCookie(&http.Cookie{Name: h.Config.SessionsConf.SessionIDKey, Value: sess.ID})
+		r.Header.Set(h.Config.CSRF.HeaderName, sess.CSRFToken)
+	}
+	return r
+}
+
+// NewTokenRequest creates request from DefaultIP with API token.
+func (h *Harness) NewTokenRequest(method, target string, body io.Reader, token string) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	r.RemoteAddr = DefaultIP + ":1234"
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// Do sends request through router and returns recorded response.
+func (h *Harness) Do(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, r)
+	return w
+}
+
+// Request sends request with session through router, sess may be nil.
+func (h *Harness) Request(method, target string, body io.Reader, sess *sessions.Session) *httptest.ResponseRecorder {
+	return h.Do(h.NewRequest(method, target, body, sess))
+}
+
+// Journal returns all journal operations.
+func (h *Harness) Journal() []journal.Operation {
+	return journal.GetAll()
+}
+
+// AssertJournal fails test if journal has no operation by username with given
+// content (request URI for handlers).
+func (h *Harness) AssertJournal(username, content string) {
+	h.t.Helper()
+	for _, op := range h.Journal() {
+		if op.Username == username && op.Content == content {
+			return
+		}
+	}
+	h.t.Errorf("No journal operation by %v with content '%v'", username, content)
+}
+
+// ListenActualizer makes session listen to Actualizer messages.
+func (h *Harness) ListenActualizer(sess *sessions.Session) {
+	h.t.Helper()
+	if err := sessions.SessionsStorage.ListenActualizer(h.NewRequest("GET", "/", nil, sess), true); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// ActualizerMessages returns messages sent to session's Actualizer so far.
+func (h *Harness) ActualizerMessages(sess *sessions.Session) []interface{} {
+	messages := []interface{}{}
+	for {
+		select {
+		case m := <-sess.Actualizer.MessageChan:
+			messages = append(messages, m)
+		default:
+			return messages
+		}
+	}
+}
+
+// AssertBanned fails test if IP ban state is not as expected.
+func (h *Harness) AssertBanned(IP string, banned bool) {
+	h.t.Helper()
+	isBanned := false
+	for _, ban := range h.Guard.List() {
+		if ban.IP == IP {
+			isBanned = true
+		}
+	}
+	if isBanned != banned {
+		h.t.Errorf("IP %v banned: %v, expected %v", IP, isBanned, banned)
+	}
+}
+
+// AssertStatus fails test if response has unexpected status code.
+func AssertStatus(t testing.TB, w *httptest.ResponseRecorder, code int) {
+	t.Helper()
+	if w.Code != code {
+		t.Errorf("Status %v, expected %v: %v", w.Code, code, w.Body.String())
+	}
+}
+
+// Users is an in-memory ghttp.UserProvider and ghttp.RoleProvider.
+type Users struct {
+	templates map[string]utemplates.UserTemplate
+	roles     map[string][]roles.Role
+	mutex     sync.RWMutex
+}
+
+// NewUsers is a Users constructor.
+func NewUsers() *Users {
+	return &Users{
+		templates: make(map[string]utemplates.UserTemplate),
+		roles:     make(map[string][]roles.Role),
+	}
+}
+
+// SetRoles sets user's roles, changes apply to live sessions at once.
+func (u *Users) SetRoles(username string, userRoles ...roles.Role) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.roles[username] = userRoles
+}
+
+// UserRoles returns user's roles.
+func (u *Users) UserRoles(username string) ([]roles.Role, error) {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.roles[username], nil
+}
+
+// Set sets user's template.
+func (u *Users) Set(username string, template utemplates.UserTemplate) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.templates[username] = template
+}
+
+// Permissions returns permissions of user's template.
+func (u *Users) Permissions(username string) ([]string, error) {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	template, ok := u.templates[username]
+	if !ok {
+		return nil, fmt.Errorf("No template for user %v", username)
+	}
+	return template.Grants(), nil
+}
+
+type nopHook struct{}
+
+func (nopHook) AfterHandler(w http.ResponseWriter, r *http.Request, sess *sessions.Session) {}
+
+type nopWatcher struct{}
+
+func (nopWatcher) Start() error { return nil }
+
+func (nopWatcher) Finish() {}
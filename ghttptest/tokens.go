@@ -0,0 +1,73 @@
+package ghttptest
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geeksteam/ghttp/tokens"
+)
+
+// Tokens is an in-memory ghttp.TokenProvider.
+type Tokens struct {
+	tokens map[string]tokens.Token
+	mutex  sync.Mutex
+}
+
+// NewTokens is a Tokens constructor.
+func NewTokens() *Tokens {
+	return &Tokens{tokens: make(map[string]tokens.Token)}
+}
+
+// Authenticate checks raw token for request from IP.
+func (t *Tokens) Authenticate(raw, IP string, now time.Time) (tokens.Token, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	token, ok := t.tokens[strings.SplitN(raw, ".", 2)[0]]
+	if !ok {
+		return tokens.Token{}, errors.New("No such token")
+	}
+	if err := tokens.Check(&token, raw, IP, now); err != nil {
+		return tokens.Token{}, err
+	}
+	t.tokens[token.ID] = token
+	return token, nil
+}
+
+// Create creates token of user.
+func (t *Tokens) Create(username, name string, scopes []string, expires int64, allowedIPs []string, now time.Time) (string, tokens.Token, error) {
+	raw, token, err := tokens.New(username, name, scopes, expires, allowedIPs, now)
+	if err != nil {
+		return "", token, err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.tokens[token.ID] = token
+	return raw, token, nil
+}
+
+// List returns tokens of user without hashes.
+func (t *Tokens) List(username string) ([]tokens.Token, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	result := []tokens.Token{}
+	for _, token := range t.tokens {
+		if token.Username == username {
+			token.Hash = ""
+			result = append(result, token)
+		}
+	}
+	return result, nil
+}
+
+// Revoke deletes token of user.
+func (t *Tokens) Revoke(username, id string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.tokens[id].Username != username {
+		return errors.New("No such token")
+	}
+	delete(t.tokens, id)
+	return nil
+}
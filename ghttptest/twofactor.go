@@ -0,0 +1,88 @@
+package ghttptest
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/geeksteam/ghttp/twofactor"
+)
+
+// SecondFactor is an in-memory ghttp.SecondFactorProvider.
+type SecondFactor struct {
+	cfg         twofactor.TwoFactor
+	enrollments map[string]twofactor.Enrollment
+	mutex       sync.Mutex
+}
+
+// NewSecondFactor is a SecondFactor constructor.
+func NewSecondFactor(cfg twofactor.TwoFactor) *SecondFactor {
+	return &SecondFactor{
+		cfg:         cfg,
+		enrollments: make(map[string]twofactor.Enrollment),
+	}
+}
+
+// Enabled checks if user has confirmed second factor.
+func (f *SecondFactor) Enabled(username string) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.enrollments[username].Confirmed, nil
+}
+
+// Enroll generates new secret of user.
+func (f *SecondFactor) Enroll(username string) (string, string, error) {
+	secret, err := twofactor.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.enrollments[username] = twofactor.Enrollment{Secret: secret}
+	return secret, twofactor.URI(f.cfg.Issuer, username, secret, f.cfg.Period, f.cfg.Digits), nil
+}
+
+// Confirm enables second factor of user.
+func (f *SecondFactor) Confirm(username, code string, now time.Time) ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	e := f.enrollments[username]
+	codes, err := f.cfg.Activate(&e, code, now)
+	if err != nil {
+		return nil, err
+	}
+	f.enrollments[username] = e
+	return codes, nil
+}
+
+// Verify checks TOTP or recovery code of user.
+func (f *SecondFactor) Verify(username, code string, now time.Time) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	e := f.enrollments[username]
+	if err := f.cfg.Check(&e, code, now); err != nil {
+		return err
+	}
+	f.enrollments[username] = e
+	return nil
+}
+
+// Disable removes second factor of user.
+func (f *SecondFactor) Disable(username string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.enrollments, username)
+	return nil
+}
+
+// Code returns TOTP code of user at t.
+func (f *SecondFactor) Code(username string, t time.Time) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	e, ok := f.enrollments[username]
+	if !ok {
+		return "", errors.New("No second factor for user " + username)
+	}
+	return twofactor.Code(e.Secret, twofactor.Counter(t, f.cfg.Period), f.cfg.Digits)
+}
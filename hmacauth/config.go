@@ -0,0 +1,15 @@
+package hmacauth
+
+type HMACAuth struct {
+	Enabled     bool  `default:"false" comment:"Accept HMAC signed requests from server-to-server clients."`
+	MaxSkew     int64 `default:"300" comment:"Max difference between signature timestamp and server time. Seconds."`
+	MaxBodySize int64 `default:"10485760" comment:"Max size of signed request body. Bytes."`
+	Clients     map[string]Client
+}
+
+// Client is a server-to-server client with shared secret.
+type Client struct {
+	Secret   string   // Shared secret for HMAC-SHA256
+	Username string   // User whose permissions client has
+	Scopes   []string // Permissions like "billing:write", "*" for all user's permissions
+}
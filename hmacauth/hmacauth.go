@@ -0,0 +1,172 @@
+// Package hmacauth authenticates server-to-server requests signed with
+// HMAC-SHA256 of method, request URI, timestamp, nonce and body hash. Nonces
+// are remembered for MaxSkew to block replays.
+package hmacauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of signed requests.
+const (
+	HeaderClient    = "X-Signature-Client"
+	HeaderTimestamp = "X-Signature-Timestamp" // Unixtime
+	HeaderNonce     = "X-Signature-Nonce"     // Random string unique for client within MaxSkew
+	HeaderSignature = "X-Signature"           // Hex HMAC-SHA256 of canonical request
+)
+
+var (
+	errUnknownClient = errors.New("Unknown signature client.")
+	errTimestamp     = errors.New("Signature timestamp is out of allowed skew.")
+	errNonce         = errors.New("Signature nonce is missing.")
+	errReplay        = errors.New("Signature nonce has been used already.")
+	errSignature     = errors.New("Invalid request signature.")
+	errBodySize      = errors.New("Signed request body is too large.")
+)
+
+// Authenticator verifies signed requests of configured clients.
+type Authenticator struct {
+	cfg       HMACAuth
+	nonces    map[string]int64 // client|nonce and unixtime when it may be forgotten
+	lastClean int64
+	mutex     sync.Mutex
+}
+
+// New constructs Authenticator for config.
+func New(c HMACAuth) *Authenticator {
+	return &Authenticator{
+		cfg:    c,
+		nonces: make(map[string]int64),
+	}
+}
+
+// IsSigned checks if request claims to be signed.
+func IsSigned(r *http.Request) bool {
+	return r.Header.Get(HeaderSignature) != ""
+}
+
+// Verify checks signature of request and returns its client ID and client.
+// Body is read and replaced, so handlers can read it again.
+func (a *Authenticator) Verify(r *http.Request, now time.Time) (string, Client, error) {
+	id := r.Header.Get(HeaderClient)
+	client, ok := a.cfg.Clients[id]
+	if !ok || client.Secret == "" {
+		return "", Client{}, errUnknownClient
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil || abs(now.Unix()-timestamp) > a.cfg.MaxSkew {
+		return "", Client{}, errTimestamp
+	}
+	nonce := r.Header.Get(HeaderNonce)
+	if nonce == "" {
+		return "", Client{}, errNonce
+	}
+
+	body, err := readBody(r, a.cfg.MaxBodySize)
+	if err != nil {
+		return "", Client{}, err
+	}
+	expected := signature(client.Secret, r.Method, r.URL.RequestURI(), r.Header.Get(HeaderTimestamp), nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(HeaderSignature))) {
+		return "", Client{}, errSignature
+	}
+
+	if !a.remember(id+"|"+nonce, now.Unix()) {
+		return "", Client{}, errReplay
+	}
+	return id, client, nil
+}
+
+// Sign sets signature headers of request for client, it's used by clients
+// written in Go and tests. Body is read and replaced.
+func Sign(r *http.Request, clientID, secret string, now time.Time) error {
+	body, err := readBody(r, -1)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(b)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	r.Header.Set(HeaderClient, clientID)
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, signature(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body))
+	return nil
+}
+
+// remember saves nonce, returns false if it's known already. Nonces older
+// than MaxSkew are forgotten since their timestamps are refused anyway.
+func (a *Authenticator) remember(key string, now int64) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if now-a.lastClean > a.cfg.MaxSkew {
+		for k, until := range a.nonces {
+			if until < now {
+				delete(a.nonces, k)
+			}
+		}
+		a.lastClean = now
+	}
+
+	if until, ok := a.nonces[key]; ok && until >= now {
+		return false
+	}
+	// Timestamp may be MaxSkew in future, so nonce is valid up to 2*MaxSkew
+	a.nonces[key] = now + 2*a.cfg.MaxSkew
+	return true
+}
+
+// signature returns hex HMAC-SHA256 of canonical request:
+// METHOD\nURI\nTIMESTAMP\nNONCE\nhex(SHA256(body)).
+func signature(secret, method, uri, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, method+"\n"+uri+"\n"+timestamp+"\n"+nonce+"\n"+hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readBody reads body up to limit bytes (any size for negative limit) and
+// replaces it with a copy.
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	reader := io.Reader(r.Body)
+	if limit >= 0 {
+		reader = io.LimitReader(r.Body, limit+1)
+	}
+	body, err := ioutil.ReadAll(reader)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if limit >= 0 && int64(len(body)) > limit {
+		return nil, errBodySize
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
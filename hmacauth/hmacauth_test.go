@@ -0,0 +1,59 @@
+package hmacauth_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geeksteam/ghttp/hmacauth"
+)
+
+func TestVerify(t *testing.T) {
+	a := hmacauth.New(hmacauth.HMACAuth{
+		MaxSkew:     300,
+		MaxBodySize: 1024,
+		Clients:     map[string]hmacauth.Client{"billing": {Secret: "s3cret", Username: "billing"}},
+	})
+	now := time.Unix(1500000000, 0)
+
+	r := httptest.NewRequest("POST", "/api/billing/pay?id=1", strings.NewReader(`{"Sum":10}`))
+	if err := hmacauth.Sign(r, "billing", "s3cret", now); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := a.Verify(r, now.Add(time.Minute)); err != nil || id != "billing" {
+		t.Fatalf("Valid signature refused: %v", err)
+	}
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != `{"Sum":10}` {
+		t.Errorf("Body is not restored: %s", body)
+	}
+
+	// Replay
+	r.Body = ioutil.NopCloser(strings.NewReader(`{"Sum":10}`))
+	if _, _, err := a.Verify(r, now.Add(time.Minute)); err == nil {
+		t.Error("Replay accepted")
+	}
+
+	// Tampered body
+	r = httptest.NewRequest("POST", "/api/billing/pay?id=1", strings.NewReader(`{"Sum":10}`))
+	hmacauth.Sign(r, "billing", "s3cret", now)
+	r.Body = ioutil.NopCloser(strings.NewReader(`{"Sum":99}`))
+	if _, _, err := a.Verify(r, now); err == nil {
+		t.Error("Tampered body accepted")
+	}
+
+	// Old timestamp
+	r = httptest.NewRequest("GET", "/api/billing/list", nil)
+	hmacauth.Sign(r, "billing", "s3cret", now)
+	if _, _, err := a.Verify(r, now.Add(10*time.Minute)); err == nil {
+		t.Error("Old signature accepted")
+	}
+
+	// Wrong secret
+	r = httptest.NewRequest("GET", "/api/billing/list", nil)
+	hmacauth.Sign(r, "billing", "wrong", now)
+	if _, _, err := a.Verify(r, now); err == nil {
+		t.Error("Signature with wrong secret accepted")
+	}
+}
@@ -0,0 +1,87 @@
+package ghttp
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/geeksteam/SHM-Backend/panicerr"
+	"github.com/geeksteam/ghttp/handlerutils"
+	"github.com/geeksteam/ghttp/journal"
+	"github.com/geeksteam/ghttp/permissions"
+	"github.com/geeksteam/ghttp/sessions"
+)
+
+// impersonationOperation is a journal operation of impersonation start and stop.
+const impersonationOperation = "impersonation"
+
+var (
+	errImpersonateRoot   = errors.New("Only root can impersonate root.")
+	errImpersonateGrants = errors.New("Can't impersonate user with permissions you don't have.")
+)
+
+// ImpersonationRequest is a JSON request to login as another user.
+type ImpersonationRequest struct {
+	Username string
+}
+
+// HandleStartImpersonation logs admin in as user from JSON ImpersonationRequest,
+// admin's session is kept to return to. Admin should have every permission
+// of user, unless admin is root. Should be mounted for admins only, like
+// router.HandleInternalFunc("/api/users/impersonate", router.HandleStartImpersonation).Require("users:impersonate").
+func (router *Router) HandleStartImpersonation(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	req := ImpersonationRequest{}
+	handlerUtils.ParseJSONBody(w, r, &req)
+	if req.Username == "" {
+		panicerr.Handlers.BadRequest(errors.New("Username is empty."))
+	}
+
+	admin, err := s.Get(r)
+	if err != nil {
+		panicerr.Core.Auth(err.Error())
+	}
+	// Admin mustn't gain permissions by acting as target
+	if granted, adminRoot := router.userPermissions(admin.Username); !adminRoot {
+		targetGranted, targetRoot := router.userPermissions(req.Username)
+		if targetRoot {
+			panicerr.Handlers.BadRequest(errImpersonateRoot)
+		}
+		if !permissions.Covers(granted, targetGranted) {
+			panicerr.Handlers.BadRequest(errImpersonateGrants)
+		}
+	}
+
+	sess, err := s.StartImpersonation(r, w, admin, req.Username)
+	if err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	router.journalImpersonation(sess, "start")
+	handlerUtils.WriteJSONBody(w, sess)
+}
+
+// HandleStopImpersonation returns admin to own session. Impersonated user may
+// lack permissions, so it should be mounted with Route.AnyUser.
+func (router *Router) HandleStopImpersonation(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess, err := s.Get(r)
+	if err != nil {
+		panicerr.Core.Auth(err.Error())
+	}
+
+	admin, err := s.StopImpersonation(r, w)
+	if err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	router.journalImpersonation(sess, "stop")
+	handlerUtils.WriteJSONBody(w, admin)
+}
+
+func (router *Router) journalImpersonation(sess *sessions.Session, content string) {
+	router.addJournal(journal.Operation{
+		SessionID:    sess.ID,
+		Date:         router.clock.Now().Format(journal.TimeLayout),
+		Username:     sess.Username,
+		Operation:    impersonationOperation,
+		Content:      content,
+		Extra:        sess.ImpersonatorSessionID,
+		Impersonator: sess.Impersonator,
+	})
+}
@@ -0,0 +1,6 @@
+package ipfilter
+
+type IPFilter struct {
+	Allow []string `comment:"IPs and CIDR ranges which bypass bruteforce and rate limits (10.0.0.0/8, 2001:db8::/32)"`
+	Deny  []string `comment:"IPs and CIDR ranges which are refused with 403 before any session lookup"`
+}
@@ -0,0 +1,49 @@
+package ipfilter
+
+import (
+	"net/http"
+
+	"github.com/geeksteam/SHM-Backend/panicerr"
+	"github.com/geeksteam/ghttp/handlerutils"
+	"github.com/geeksteam/ghttp/sessions"
+)
+
+// Lists is a JSON representation of both lists.
+type Lists struct {
+	Allow []string
+	Deny  []string
+}
+
+// Entry is a JSON request to add or remove range.
+type Entry struct {
+	List string // allow or deny
+	CIDR string
+}
+
+// HandleList writes both lists as JSON. Handlers of this file are supposed to
+// be mounted with Router.HandleInternalFunc for admins only.
+func HandleList(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	allow, _ := List(Allowlist)
+	deny, _ := List(Denylist)
+	handlerUtils.WriteJSONBody(w, Lists{Allow: allow, Deny: deny})
+}
+
+// HandleAdd adds range from JSON Entry to list.
+func HandleAdd(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	entry := Entry{}
+	handlerUtils.ParseJSONBody(w, r, &entry)
+	if err := Add(entry.List, entry.CIDR); err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	handlerUtils.SendOkStatus(w)
+}
+
+// HandleRemove removes range from JSON Entry from list.
+func HandleRemove(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	entry := Entry{}
+	handlerUtils.ParseJSONBody(w, r, &entry)
+	if err := Remove(entry.List, entry.CIDR); err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	handlerUtils.SendOkStatus(w)
+}
@@ -0,0 +1,173 @@
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Allowlist and Denylist are names of lists for Add and Remove.
+const (
+	Allowlist = "allow"
+	Denylist  = "deny"
+)
+
+var (
+	// Local variables
+	lists = map[string]*list{
+		Allowlist: newList(),
+		Denylist:  newList(),
+	}
+	mutex sync.RWMutex
+
+	errNoList = fmt.Errorf("No such list, should be '%v' or '%v'", Allowlist, Denylist)
+)
+
+// list holds networks and lookup trie built from them.
+type list struct {
+	networks map[string]*net.IPNet
+	trie     *trie
+}
+
+func newList() *list {
+	return &list{networks: make(map[string]*net.IPNet), trie: newTrie()}
+}
+
+// SetConfig replaces both lists with ones from config. Invalid entries are
+// skipped and returned as error.
+func SetConfig(c IPFilter) error {
+	allow, errAllow := parseList(c.Allow)
+	deny, errDeny := parseList(c.Deny)
+
+	mutex.Lock()
+	lists[Allowlist] = allow
+	lists[Denylist] = deny
+	mutex.Unlock()
+
+	if errAllow != nil {
+		return errAllow
+	}
+	return errDeny
+}
+
+// IsAllowed checks if ip is in allowlist.
+func IsAllowed(ip string) bool {
+	return contains(Allowlist, ip)
+}
+
+// IsDenied checks if ip is in denylist.
+func IsDenied(ip string) bool {
+	return contains(Denylist, ip)
+}
+
+// Add adds IP or CIDR range to list.
+func Add(listName, cidr string) error {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	l, ok := lists[listName]
+	if !ok {
+		return errNoList
+	}
+	l.networks[network.String()] = network
+	l.trie.insert(network)
+	return nil
+}
+
+// Remove removes IP or CIDR range from list. Range should be the same as added
+// one, it doesn't split wider ranges.
+func Remove(listName, cidr string) error {
+	network, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	l, ok := lists[listName]
+	if !ok {
+		return errNoList
+	}
+	if _, ok := l.networks[network.String()]; !ok {
+		return fmt.Errorf("%v is not in %vlist", network, listName)
+	}
+	delete(l.networks, network.String())
+
+	// Rebuild trie, removing is rare
+	l.trie = newTrie()
+	for _, n := range l.networks {
+		l.trie.insert(n)
+	}
+	return nil
+}
+
+// List returns sorted ranges of list.
+func List(listName string) ([]string, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	l, ok := lists[listName]
+	if !ok {
+		return nil, errNoList
+	}
+	result := make([]string, 0, len(l.networks))
+	for k := range l.networks {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func contains(listName, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return lists[listName].trie.contains(addr)
+}
+
+func parseList(cidrs []string) (*list, error) {
+	l := newList()
+	invalid := []string{}
+	for _, cidr := range cidrs {
+		network, err := parseCIDR(cidr)
+		if err != nil {
+			invalid = append(invalid, cidr)
+			continue
+		}
+		l.networks[network.String()] = network
+		l.trie.insert(network)
+	}
+	if len(invalid) > 0 {
+		return l, fmt.Errorf("Invalid IPs or CIDR ranges: %v", strings.Join(invalid, ", "))
+	}
+	return l, nil
+}
+
+// parseCIDR parses CIDR range, single IPs are treated as /32 or /128 ranges.
+func parseCIDR(cidr string) (*net.IPNet, error) {
+	cidr = strings.TrimSpace(cidr)
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid IP '%v'", cidr)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	return network, err
+}
@@ -0,0 +1,39 @@
+package ipfilter
+
+import "testing"
+
+func TestLists(t *testing.T) {
+	err := SetConfig(IPFilter{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32", "192.168.1.5"},
+		Deny:  []string{"203.0.113.0/24"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"10.1.2.3":        true,
+		"11.0.0.1":        false,
+		"192.168.1.5":     true,
+		"192.168.1.6":     false,
+		"2001:db8::1":     true,
+		"2001:db9::1":     false,
+		"::ffff:10.0.0.1": true,
+		"invalid":         false,
+	}
+	for ip, allowed := range cases {
+		if IsAllowed(ip) != allowed {
+			t.Errorf("IsAllowed(%v) != %v", ip, allowed)
+		}
+	}
+	if !IsDenied("203.0.113.7") || IsDenied("10.0.0.1") {
+		t.Error("denylist mismatch")
+	}
+
+	if err := Remove(Allowlist, "10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if IsAllowed("10.1.2.3") {
+		t.Error("removed range is still allowed")
+	}
+}
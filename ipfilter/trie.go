@@ -0,0 +1,73 @@
+package ipfilter
+
+import "net"
+
+// trie is a binary prefix trie of networks. Lookup time depends on address
+// length only, not on number of networks in list.
+type trie struct {
+	v4 *node
+	v6 *node
+}
+
+type node struct {
+	children [2]*node
+	terminal bool // Network ends here, all addresses below are matched
+}
+
+func newTrie() *trie {
+	return &trie{v4: &node{}, v6: &node{}}
+}
+
+// insert adds network to trie.
+func (t *trie) insert(network *net.IPNet) {
+	ones, bits := network.Mask.Size()
+	ip, n := t.root(network.IP)
+	// IPv4-mapped IPv6 network
+	if bits == 128 && len(ip) == net.IPv4len {
+		if ones -= 96; ones < 0 {
+			ones = 0
+		}
+	}
+
+	for i := 0; i < ones; i++ {
+		if n.terminal {
+			// Wider network is in trie already
+			return
+		}
+		b := bit(ip, i)
+		if n.children[b] == nil {
+			n.children[b] = &node{}
+		}
+		n = n.children[b]
+	}
+	n.terminal = true
+	// Narrower networks are useless now
+	n.children = [2]*node{}
+}
+
+// contains checks if ip belongs to any network in trie.
+func (t *trie) contains(addr net.IP) bool {
+	ip, n := t.root(addr)
+	for i := 0; n != nil; i++ {
+		if n.terminal {
+			return true
+		}
+		if i == len(ip)*8 {
+			return false
+		}
+		n = n.children[bit(ip, i)]
+	}
+	return false
+}
+
+// root returns ip in its shortest form and trie root for its family.
+func (t *trie) root(ip net.IP) (net.IP, *node) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, t.v4
+	}
+	return ip.To16(), t.v6
+}
+
+func bit(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
@@ -5,4 +5,10 @@ type Journal struct {
 	BucketForOperations string `default:"Operations" comment:"name of bucket which holds operations"`
 	Capacity            int    `default:"60" comment:"How much days store in journal"`
 	DataEncoding        string `default:"mspack" comment:"Encoding of values for boltdb storage. Values:[mspack, json]"`
+	Async               bool   `default:"true" comment:"Add operations to buffer which is written to db in background batches"`
+	BufferSize          int    `default:"1024" comment:"Max number of operations waiting to be written"`
+	BatchSize           int    `default:"100" comment:"Number of operations which triggers write"`
+	FlushInterval       int64  `default:"1" comment:"Max time operations wait in buffer. Seconds."`
+	Overflow            string `default:"block" comment:"What to do with operations when buffer is full. Values:[block, drop, spill]"`
+	SpillFile           string `default:"./db/journal.spill" comment:"File for operations which don't fit in buffer or fail to write, they are written to db on next start"`
 }
@@ -1,13 +1,15 @@
 package journal
 
 import (
-	"bytes"
 	"errors"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/geeksteam/GoTools/boltdb"
+	"github.com/geeksteam/GoTools/logger"
+	"github.com/geeksteam/ghttp/clock"
 )
 
 const (
@@ -15,18 +17,107 @@ const (
 	// functions which require time strings.
 	TimeLayout = time.RFC3339
 
-	dbMode       = os.FileMode(0600)
+	dbMode = os.FileMode(0600)
+	// keyDelimiter separates date and username in keys of first version.
 	keyDelimiter = "|"
+	// openTimeout limits waiting for file lock held by another process.
+	openTimeout = time.Second
 )
 
 var (
 	cfg Journal
+	clk = clock.Real
+
+	// Default journal used by package functions, opened on first use
+	defaultDB     *DB
+	defaultWriter *Writer // Background writer of defaultDB if Async
+	dropped       uint64  // Dropped by closed default writers
+	mutex         sync.Mutex
+
+	errNoBucket = errors.New("Journal bucket for operations not found")
 )
 
+// SetConfig sets config of default journal. Default journal opened with
+// previous config is closed.
 func SetConfig(c Journal) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	closeDefault()
 	cfg = c
 }
 
+// SetClock sets clock used for operations dates and journal capacity.
+func SetClock(c clock.Clock) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	clk = c
+	if defaultDB != nil {
+		defaultDB.SetClock(c)
+	}
+}
+
+// Close writes buffered operations and closes default journal, it's reopened
+// on next use. Should be called on graceful stop.
+func Close() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return closeDefault()
+}
+
+// Flush writes buffered operations of default journal now.
+func Flush() error {
+	mutex.Lock()
+	w := defaultWriter
+	mutex.Unlock()
+
+	if w == nil {
+		return nil
+	}
+	return w.Flush()
+}
+
+// Dropped returns number of operations dropped by default journal with
+// OverflowDrop policy since start.
+func Dropped() uint64 {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if defaultWriter == nil {
+		return dropped
+	}
+	return dropped + defaultWriter.Dropped()
+}
+
+// Pending returns number of operations buffered by default journal, which
+// are not written yet. It's always 0 without Async.
+func Pending() int {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if defaultWriter == nil {
+		return 0
+	}
+	return defaultWriter.Pending()
+}
+
+func closeDefault() error {
+	if defaultWriter != nil {
+		if err := defaultWriter.Close(); err != nil {
+			logger.Error("Can't write journal operations on close: " + err.Error())
+		}
+		dropped += defaultWriter.Dropped()
+		defaultWriter = nil
+	}
+	if defaultDB == nil {
+		return nil
+	}
+	err := defaultDB.Close()
+	defaultDB = nil
+	return err
+}
+
 // Operation is a journal operations struct representation.
 type Operation struct {
 	SessionID string
@@ -35,28 +126,125 @@ type Operation struct {
 	Operation string // Название операции
 	Content   string // Содержание операции
 	Extra     string // Дополнительная информация
+
+	Impersonator string // Админ, работающий от имени Username, если есть
+	Auth         string // Способ аутентификации без cookie, например "token"
+
+	stamp time.Time // Время добавления с наносекундами для ключа
+}
+
+// DB is a journal which keeps BoltDB open until Close. Journal is a name of
+// config, so instances are called DB.
+type DB struct {
+	cfg   Journal
+	db    *bolt.DB
+	clock clock.Clock
+	mutex sync.RWMutex
+}
+
+// Open opens journal's BoltDB and creates operations and index buckets.
+// Keys of older versions are migrated once.
+func Open(c Journal) (*DB, error) {
+	db, err := bolt.Open(c.BoltDB, dbMode, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+	j := &DB{cfg: c, db: db, clock: clock.Real}
+	if err := db.Update(j.createBuckets); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// SetClock sets clock used for operations dates and journal capacity.
+func (j *DB) SetClock(c clock.Clock) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.clock = c
+}
+
+// Close closes BoltDB.
+func (j *DB) Close() error {
+	return j.db.Close()
 }
 
 // GetAll fetches all operation from BoltDB storage.
 func GetAll() (result []Operation) {
-	view(func(k, v []byte) {
-		op := &Operation{}
-		boltdb.DecodeValue(v, op, cfg.DataEncoding)
-		result = append(result, *op)
-	})
+	j, err := getDefault()
+	if err != nil {
+		return nil
+	}
+	Flush()
+	result, _ = j.GetAll()
 	return
 }
 
 // FetchByDate attempts to fetch all operations, which are operated by user with
 // username (or all users if username = "") and in time bounds beetween dateFrom and dateTo dates.
 func FetchByDate(dateFrom, dateTo, username string) ([]Operation, error) {
-	result := []Operation{}
+	j, err := getDefault()
+	if err != nil {
+		return []Operation{}, err
+	}
+	Flush()
+	return j.FetchByDate(dateFrom, dateTo, username)
+}
 
-	db, err := bolt.Open(cfg.BoltDB, dbMode, nil)
+// FetchBySession fetches all operations made within session with sessionID.
+func FetchBySession(sessionID string) ([]Operation, error) {
+	j, err := getDefault()
 	if err != nil {
-		return result, err
+		return []Operation{}, err
+	}
+	Flush()
+	return j.FetchBySession(sessionID)
+}
+
+// Add attempts to add given operation into BoltDB storage. With Async config
+// operation is buffered and written in background.
+func Add(operation Operation) error {
+	j, err := getDefault()
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	w := defaultWriter
+	mutex.Unlock()
+	if w != nil {
+		return w.Add(operation)
+	}
+	return j.Add(operation)
+}
+
+// CleanOld Delete entries which out of date
+func CleanOld() error {
+	j, err := getDefault()
+	if err != nil {
+		return err
 	}
-	defer db.Close()
+	return j.CleanOld()
+}
+
+// GetAll fetches all operations.
+func (j *DB) GetAll() ([]Operation, error) {
+	result := []Operation{}
+	err := j.view(func(k, v []byte) error {
+		op := Operation{}
+		if err := boltdb.DecodeValue(v, &op, j.cfg.DataEncoding); err != nil {
+			return err
+		}
+		result = append(result, op)
+		return nil
+	})
+	return result, err
+}
+
+// FetchByDate fetches operations of user with username (or all users if
+// username = "") between dateFrom and dateTo dates.
+func (j *DB) FetchByDate(dateFrom, dateTo, username string) ([]Operation, error) {
+	result := []Operation{}
 
 	//halper func for parsing date and handling errors
 	parseTime := func(source string) (time.Time, error) {
@@ -77,110 +265,160 @@ func FetchByDate(dateFrom, dateTo, username string) ([]Operation, error) {
 		return result, err
 	}
 
-	now, err := parseTime(time.Now().Format(TimeLayout))
+	now, err := parseTime(j.now().Format(TimeLayout))
 	if err != nil {
 		return result, err
 	}
 
 	if to.Equal(now) {
-		to = time.Now()
+		// Include operations added right now
+		to = j.now().Add(time.Nanosecond)
 	} else {
 		to = to.Add(24 * time.Hour)
 	}
 
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(cfg.BucketForOperations))
-		c := b.Cursor()
-		for k, v := c.Seek([]byte(from.String())); k != nil && bytes.Compare(k, []byte(to.String())) <= 0; k, v = c.Next() {
-			//check username
-			if username != "" && !bytes.HasSuffix(k, []byte(username)) {
-				continue
+	err = j.db.View(func(tx *bolt.Tx) error {
+		b, err := j.buckets(tx)
+		if err != nil {
+			return err
+		}
+		collect := func(key []byte) error {
+			op := Operation{}
+			if err := boltdb.DecodeValue(b.operations.Get(key), &op, j.cfg.DataEncoding); err != nil {
+				return err
 			}
+			result = append(result, op)
+			return nil
+		}
 
-			op := &Operation{}
-			boltdb.DecodeValue(v, op, cfg.DataEncoding)
-			result = append(result, *op)
+		if username != "" {
+			return scanIndex(b.byUsername, username, from, to, collect)
+		}
+		c := b.operations.Cursor()
+		for k, _ := c.Seek(timeKey(from)); k != nil && keyTime(k).Before(to); k, _ = c.Next() {
+			if err := collect(k); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
+	return result, err
+}
 
-	return result, nil
+// FetchBySession fetches operations of session in time order.
+func (j *DB) FetchBySession(sessionID string) ([]Operation, error) {
+	result := []Operation{}
+	err := j.db.View(func(tx *bolt.Tx) error {
+		b, err := j.buckets(tx)
+		if err != nil {
+			return err
+		}
+		return scanIndex(b.bySession, sessionID, time.Unix(0, 0), time.Unix(0, 1<<63-1), func(key []byte) error {
+			op := Operation{}
+			if err := boltdb.DecodeValue(b.operations.Get(key), &op, j.cfg.DataEncoding); err != nil {
+				return err
+			}
+			result = append(result, op)
+			return nil
+		})
+	})
+	return result, err
 }
 
-// Add attempts to add given operation into BoltDB storage.
-func Add(operation Operation) error {
-	db, err := bolt.Open(cfg.BoltDB, dbMode, nil)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte(cfg.BucketForOperations))
+// Add adds operation dated by journal's clock.
+func (j *DB) Add(operation Operation) error {
+	return j.put([]Operation{j.stamp(operation)})
+}
+
+// stamp dates operation by journal's clock.
+func (j *DB) stamp(operation Operation) Operation {
+	operation.stamp = j.now().UTC()
+	operation.Date = operation.stamp.Format(TimeLayout)
+	return operation
+}
+
+// put adds dated operations in one transaction.
+func (j *DB) put(ops []Operation) error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		b, err := j.buckets(tx)
 		if err != nil {
 			return err
 		}
-		operation.Date = getCurrentDateString()
-		key := createKey(operation.Date, operation.Username)
-		value, err := boltdb.EncodeValue(operation, cfg.DataEncoding)
-		if errPut := bucket.Put([]byte(key), value); errPut != nil {
-			err = errPut
+		for _, op := range ops {
+			if err := j.insert(b, op); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
-	if err != nil {
-		return err
-	}
-	return nil
 }
 
-// CleanOld Delete entries which out of date
-func CleanOld() error {
-	db, err := bolt.Open(cfg.BoltDB, dbMode, nil)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
+// CleanOld deletes operations older than Capacity days.
+func (j *DB) CleanOld() error {
+	//Calculate latest date
+	latest := j.now().Add(time.Duration(-j.cfg.Capacity) * 24 * time.Hour)
 
-	return db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(cfg.BucketForOperations))
-		if bucket == nil {
-			return errors.New("Jornal bucket for operation not found")
+	return j.db.Update(func(tx *bolt.Tx) error {
+		b, err := j.buckets(tx)
+		if err != nil {
+			return err
 		}
 
-		cursor := bucket.Cursor()
-
-		//Calculate latest date
-		latest := time.Now().Add(time.Duration(-cfg.Capacity) * 24 * time.Hour).Format(TimeLayout)
-
-		for k, _ := cursor.Seek([]byte("0")); k != nil && bytes.Compare(k, []byte(latest)) <= 0; k, _ = cursor.Next() {
-			cursor.Delete()
+		// Deleting while iterating skips keys, so collect them first
+		type entry struct{ key, value []byte }
+		var old []entry
+		c := b.operations.Cursor()
+		for k, v := c.First(); k != nil && !keyTime(k).After(latest); k, v = c.Next() {
+			old = append(old, entry{append([]byte{}, k...), append([]byte{}, v...)})
+		}
+		for _, e := range old {
+			if err := j.remove(b, e.key, e.value); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
 }
 
-func getCurrentDateString() string {
-	t := time.Now()
-	return t.UTC().Format(TimeLayout)
+func (j *DB) now() time.Time {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+	return j.clock.Now()
 }
 
-func createKey(date, username string) string {
-	return date + keyDelimiter + username
-}
+// getDefault returns default journal, opening it with config if needed.
+func getDefault() (*DB, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
 
-// view is a generic walk function. Maps given function to all elements of
-// BucketForOperations bucket.
-func view(f func(k, v []byte)) error {
-	db, err := bolt.Open(cfg.BoltDB, dbMode, nil)
+	if defaultDB != nil {
+		return defaultDB, nil
+	}
+	j, err := Open(cfg)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	j.SetClock(clk)
+	defaultDB = j
+	if cfg.Async {
+		defaultWriter = NewWriter(j, cfg)
 	}
-	defer db.Close()
-	return db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(cfg.BucketForOperations))
+	return j, nil
+}
+
+// view is a generic walk function. Maps given function to all elements of
+// BucketForOperations bucket, walk stops on first error.
+func (j *DB) view(f func(k, v []byte) error) error {
+	return j.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(j.cfg.BucketForOperations))
+		if b == nil {
+			return errNoBucket
+		}
 		c := b.Cursor()
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			f(k, v)
+			if err := f(k, v); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -0,0 +1,231 @@
+package journal_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/geeksteam/ghttp/ghttptest"
+	"github.com/geeksteam/ghttp/journal"
+)
+
+func TestCleanOld(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	journal.SetConfig(journal.Journal{
+		BoltDB:              filepath.Join(dir, "journal.db"),
+		BucketForOperations: "Operations",
+		Capacity:            60,
+		DataEncoding:        "json",
+	})
+	clock := ghttptest.NewFakeClock(time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC))
+	journal.SetClock(clock)
+
+	if err := journal.Add(journal.Operation{Username: "bob", Operation: "dns"}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Add(59 * 24 * time.Hour)
+	if err := journal.CleanOld(); err != nil {
+		t.Fatal(err)
+	}
+	if len(journal.GetAll()) != 1 {
+		t.Fatal("operation removed before capacity passed")
+	}
+
+	clock.Add(2 * 24 * time.Hour)
+	if err := journal.CleanOld(); err != nil {
+		t.Fatal(err)
+	}
+	if len(journal.GetAll()) != 0 {
+		t.Fatal("old operation has not been removed")
+	}
+}
+
+func TestDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := journal.Journal{
+		BoltDB:              filepath.Join(dir, "journal.db"),
+		BucketForOperations: "Operations",
+		Capacity:            60,
+		DataEncoding:        "json",
+	}
+	j, err := journal.Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// File is locked while journal is open
+	if _, err := journal.Open(c); err == nil {
+		t.Fatal("Second open should fail on file lock")
+	}
+
+	if err := j.Add(journal.Operation{Username: "bob", Operation: "dns"}); err != nil {
+		t.Fatal(err)
+	}
+	if ops, err := j.GetAll(); err != nil || len(ops) != 1 || ops[0].Username != "bob" {
+		t.Fatalf("Unexpected operations %v, %v", ops, err)
+	}
+
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Add(journal.Operation{Username: "bob"}); err == nil {
+		t.Error("Add to closed journal should fail")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	j, err := journal.Open(journal.Journal{
+		BoltDB:              filepath.Join(dir, "journal.db"),
+		BucketForOperations: "Operations",
+		Capacity:            60,
+		DataEncoding:        "json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	clock := ghttptest.NewFakeClock(time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC))
+	j.SetClock(clock)
+
+	// Operations of the same user within one second are kept in order
+	for _, op := range []journal.Operation{
+		{Username: "bob", SessionID: "s1", Content: "1"},
+		{Username: "bob", SessionID: "s1", Content: "2"},
+		{Username: "alice_bob", SessionID: "s2", Content: "3"},
+		{Username: "bob", SessionID: "s3", Content: "4"},
+	} {
+		if err := j.Add(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Add(48 * time.Hour)
+	if err := j.Add(journal.Operation{Username: "bob", SessionID: "s3", Content: "5"}); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := func(ops []journal.Operation, err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := ""
+		for _, op := range ops {
+			result += op.Content
+		}
+		return result
+	}
+
+	if c := contents(j.GetAll()); c != "12345" {
+		t.Errorf("All operations %v, expected 12345", c)
+	}
+	if c := contents(j.FetchByDate("2016-01-01T00:00:00Z", "2016-01-01T00:00:00Z", "bob")); c != "124" {
+		t.Errorf("Operations of bob %v, expected 124", c)
+	}
+	if c := contents(j.FetchByDate("2016-01-02T00:00:00Z", "2016-01-03T12:00:00Z", "")); c != "5" {
+		t.Errorf("Operations until now %v, expected 5", c)
+	}
+	if c := contents(j.FetchBySession("s3")); c != "45" {
+		t.Errorf("Operations of session %v, expected 45", c)
+	}
+
+	clock.Add(59 * 24 * time.Hour)
+	if err := j.CleanOld(); err != nil {
+		t.Fatal(err)
+	}
+	if c := contents(j.FetchBySession("s3")); c != "5" {
+		t.Errorf("Operations of session after clean %v, expected 5", c)
+	}
+	if c := contents(j.FetchByDate("2016-01-01T00:00:00Z", "2016-03-01T00:00:00Z", "bob")); c != "5" {
+		t.Errorf("Operations of bob after clean %v, expected 5", c)
+	}
+}
+
+func TestMigrateKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := journal.Journal{
+		BoltDB:              filepath.Join(dir, "journal.db"),
+		BucketForOperations: "Operations",
+		Capacity:            60,
+		DataEncoding:        "json",
+	}
+
+	// Journal with keys of first version
+	db, err := bolt.Open(c.BoltDB, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte(c.BucketForOperations))
+		if err != nil {
+			return err
+		}
+		for _, op := range []journal.Operation{
+			{Date: "2016-01-02T12:00:00Z", Username: "bob", SessionID: "s2", Content: "2"},
+			{Date: "2016-01-01T12:00:00Z", Username: "bob", SessionID: "s1", Content: "1"},
+		} {
+			value, err := json.Marshal(op)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(op.Date+"|"+op.Username), value); err != nil {
+				return err
+			}
+		}
+		// Operation without date is dated by migration, undecodable one is set aside
+		value, _ := json.Marshal(journal.Operation{Username: "eve", Content: "3"})
+		if err := b.Put([]byte("unknown|eve"), value); err != nil {
+			return err
+		}
+		return b.Put([]byte("2016-01-03T12:00:00Z|eve"), []byte("not json"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	for i := 0; i < 2; i++ {
+		j, err := journal.Open(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops, err := j.FetchByDate("2016-01-01T00:00:00Z", "2016-01-02T00:00:00Z", "bob")
+		if err != nil || len(ops) != 2 || ops[0].Content != "1" || ops[1].Content != "2" {
+			t.Errorf("Unexpected migrated operations %v, %v", ops, err)
+		}
+		if ops, err := j.FetchBySession("s2"); err != nil || len(ops) != 1 {
+			t.Errorf("Unexpected operations of migrated session %v, %v", ops, err)
+		}
+		if ops, err := j.GetAll(); err != nil || len(ops) != 3 || ops[2].Content != "3" {
+			t.Errorf("Unexpected migrated operations %v, %v", ops, err)
+		}
+		if ops, err := j.FetchByDate("2016-01-01T00:00:00Z", time.Now().UTC().Format(journal.TimeLayout), "eve"); err != nil || len(ops) != 1 {
+			t.Errorf("Operation without date should be dated by migration: %v, %v", ops, err)
+		}
+		j.Close()
+	}
+}
@@ -0,0 +1,212 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/geeksteam/GoTools/boltdb"
+	"github.com/geeksteam/GoTools/logger"
+)
+
+// Keys of operations are 8 bytes of UnixNano and 8 bytes of bucket sequence,
+// both big endian, so they are unique and sorted by time. Indexes map
+// "username\x00key" and "sessionID\x00key" to nothing.
+const (
+	keyLength = 16
+
+	usernameIndexSuffix = "ByUsername"
+	sessionIndexSuffix  = "BySession"
+	metaSuffix          = "Meta"
+	undecodableSuffix   = "Undecodable" // Operations migration couldn't decode
+
+	// keysVersion is a version of keys format stored in meta bucket.
+	keysVersion = 2
+)
+
+var (
+	versionKey     = []byte("version")
+	indexSeparator = []byte{0}
+)
+
+// buckets of journal within transaction.
+type buckets struct {
+	operations, byUsername, bySession *bolt.Bucket
+}
+
+func (j *DB) buckets(tx *bolt.Tx) (buckets, error) {
+	b := buckets{
+		operations: tx.Bucket([]byte(j.cfg.BucketForOperations)),
+		byUsername: tx.Bucket([]byte(j.cfg.BucketForOperations + usernameIndexSuffix)),
+		bySession:  tx.Bucket([]byte(j.cfg.BucketForOperations + sessionIndexSuffix)),
+	}
+	if b.operations == nil || b.byUsername == nil || b.bySession == nil {
+		return b, errNoBucket
+	}
+	return b, nil
+}
+
+// createBuckets creates journal buckets and migrates keys of older versions.
+func (j *DB) createBuckets(tx *bolt.Tx) error {
+	for _, name := range []string{"", usernameIndexSuffix, sessionIndexSuffix, metaSuffix} {
+		if _, err := tx.CreateBucketIfNotExists([]byte(j.cfg.BucketForOperations + name)); err != nil {
+			return err
+		}
+	}
+	meta := tx.Bucket([]byte(j.cfg.BucketForOperations + metaSuffix))
+	if v := meta.Get(versionKey); v != nil && binary.BigEndian.Uint64(v) >= keysVersion {
+		return nil
+	}
+
+	if err := j.migrate(tx); err != nil {
+		return err
+	}
+	version := make([]byte, 8)
+	binary.BigEndian.PutUint64(version, keysVersion)
+	return meta.Put(versionKey, version)
+}
+
+// migrate rewrites "RFC3339|username" keys of first version to unique keys
+// and builds indexes. Operations with unparsable keys get their Date.
+// Undecodable operations are moved to own bucket as is, so they don't
+// break journal.
+func (j *DB) migrate(tx *bolt.Tx) error {
+	b, err := j.buckets(tx)
+	if err != nil {
+		return err
+	}
+
+	type entry struct{ key, value []byte }
+	var old []entry
+	err = b.operations.ForEach(func(k, v []byte) error {
+		if len(k) != keyLength || bytes.IndexByte(k, '|') >= 0 {
+			old = append(old, entry{append([]byte{}, k...), append([]byte{}, v...)})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range old {
+		op := Operation{}
+		if err := boltdb.DecodeValue(e.value, &op, j.cfg.DataEncoding); err != nil {
+			logger.Error(fmt.Sprintf("Can't migrate journal operation %q: %v", e.key, err))
+			if err := j.setAside(tx, b, e.key, e.value); err != nil {
+				return err
+			}
+			continue
+		}
+		date := strings.SplitN(string(e.key), keyDelimiter, 2)[0]
+		if t, err := time.Parse(TimeLayout, date); err == nil {
+			op.stamp = t
+		}
+		if err := b.operations.Delete(e.key); err != nil {
+			return err
+		}
+		if err := j.insert(b, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setAside moves operation to undecodable bucket.
+func (j *DB) setAside(tx *bolt.Tx, b buckets, key, value []byte) error {
+	undecodable, err := tx.CreateBucketIfNotExists([]byte(j.cfg.BucketForOperations + undecodableSuffix))
+	if err != nil {
+		return err
+	}
+	if err := undecodable.Put(key, value); err != nil {
+		return err
+	}
+	return b.operations.Delete(key)
+}
+
+// insert puts operation with new key and indexes it.
+func (j *DB) insert(b buckets, op Operation) error {
+	stamp := op.stamp
+	if stamp.IsZero() {
+		// Operations spilled to file lose nanoseconds
+		var err error
+		if stamp, err = time.Parse(TimeLayout, op.Date); err != nil {
+			// Key of zero time would outlive capacity
+			stamp = j.now()
+		}
+	}
+	seq, err := b.operations.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := newKey(stamp, seq)
+
+	value, err := boltdb.EncodeValue(op, j.cfg.DataEncoding)
+	if err != nil {
+		return err
+	}
+	if err := b.operations.Put(key, value); err != nil {
+		return err
+	}
+	if err := b.byUsername.Put(indexKey(op.Username, key), []byte{}); err != nil {
+		return err
+	}
+	return b.bySession.Put(indexKey(op.SessionID, key), []byte{})
+}
+
+// remove deletes operation with key and its index entries.
+func (j *DB) remove(b buckets, key, value []byte) error {
+	op := Operation{}
+	if err := boltdb.DecodeValue(value, &op, j.cfg.DataEncoding); err != nil {
+		return err
+	}
+	if err := b.byUsername.Delete(indexKey(op.Username, key)); err != nil {
+		return err
+	}
+	if err := b.bySession.Delete(indexKey(op.SessionID, key)); err != nil {
+		return err
+	}
+	return b.operations.Delete(key)
+}
+
+// scanIndex calls f for keys of operations indexed by value in time order
+// within [from, to).
+func scanIndex(index *bolt.Bucket, value string, from, to time.Time, f func(key []byte) error) error {
+	prefix := append([]byte(value), indexSeparator...)
+	c := index.Cursor()
+	for k, _ := c.Seek(append(prefix, timeKey(from)...)); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		key := k[len(prefix):]
+		if len(key) != keyLength || !keyTime(key).Before(to) {
+			break
+		}
+		if err := f(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, keyLength)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// timeKey returns smallest key at t for seeks.
+func timeKey(t time.Time) []byte {
+	return newKey(t, 0)
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+}
+
+func indexKey(value string, key []byte) []byte {
+	result := make([]byte, 0, len(value)+1+len(key))
+	result = append(result, value...)
+	result = append(result, indexSeparator...)
+	return append(result, key...)
+}
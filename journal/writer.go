@@ -0,0 +1,251 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/geeksteam/GoTools/logger"
+)
+
+// Overflow policies of Writer.
+const (
+	OverflowBlock = "block" // Wait for free place in buffer
+	OverflowDrop  = "drop"  // Drop operation and count it
+	OverflowSpill = "spill" // Append operation to SpillFile
+)
+
+var errWriterClosed = errors.New("Journal writer is closed")
+
+// Writer adds operations to DB in background batches. Batch is written when
+// it reaches BatchSize or every FlushInterval.
+type Writer struct {
+	db      *DB
+	cfg     Journal
+	queue   chan Operation
+	flushes chan chan error
+	stop    chan bool
+	done    chan error
+	dropped uint64
+	pending int64 // Operations put to buffer and not written yet
+
+	closed     bool
+	mutex      sync.RWMutex
+	spillMutex sync.Mutex
+}
+
+// NewWriter starts Writer over db. Operations spilled before are written to
+// db first.
+func NewWriter(db *DB, c Journal) *Writer {
+	if c.BufferSize <= 0 {
+		c.BufferSize = 1024
+	}
+	if c.BatchSize <= 0 || c.BatchSize > c.BufferSize {
+		c.BatchSize = c.BufferSize
+	}
+
+	w := &Writer{
+		db:      db,
+		cfg:     c,
+		queue:   make(chan Operation, c.BufferSize),
+		flushes: make(chan chan error),
+		stop:    make(chan bool),
+		done:    make(chan error),
+	}
+	if err := w.replaySpill(); err != nil {
+		logger.Error("Can't write spilled journal operations: " + err.Error())
+	}
+	go w.run()
+	return w
+}
+
+// Add dates operation and puts it to buffer. When buffer is full operation
+// is handled by Overflow policy.
+func (w *Writer) Add(operation Operation) error {
+	operation = w.db.stamp(operation)
+
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return errWriterClosed
+	}
+
+	select {
+	case w.queue <- operation:
+		atomic.AddInt64(&w.pending, 1)
+		return nil
+	default:
+	}
+
+	switch w.cfg.Overflow {
+	case OverflowDrop:
+		atomic.AddUint64(&w.dropped, 1)
+		return nil
+	case OverflowSpill:
+		return w.spill([]Operation{operation})
+	default:
+		atomic.AddInt64(&w.pending, 1)
+		w.queue <- operation
+		return nil
+	}
+}
+
+// Flush writes buffered operations now. Error of failed write is returned
+// even if operations were spilled.
+func (w *Writer) Flush() error {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return errWriterClosed
+	}
+
+	result := make(chan error)
+	w.flushes <- result
+	return <-result
+}
+
+// Pending returns number of buffered operations, which are not written yet.
+func (w *Writer) Pending() int {
+	return int(atomic.LoadInt64(&w.pending))
+}
+
+// Dropped returns number of operations dropped by OverflowDrop policy.
+func (w *Writer) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Close writes buffered operations and stops Writer. DB is left open.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mutex.Unlock()
+
+	w.stop <- true
+	return <-w.done
+}
+
+func (w *Writer) run() {
+	ticker := time.NewTicker(time.Duration(w.cfg.FlushInterval) * time.Second)
+	if w.cfg.FlushInterval <= 0 {
+		ticker = time.NewTicker(time.Second)
+	}
+	defer ticker.Stop()
+
+	batch := make([]Operation, 0, w.cfg.BatchSize)
+	for {
+		select {
+		case op := <-w.queue:
+			batch = append(batch, op)
+			if len(batch) >= w.cfg.BatchSize {
+				batch, _ = w.write(batch)
+			}
+		case <-ticker.C:
+			batch, _ = w.write(batch)
+		case result := <-w.flushes:
+			var err error
+			batch, err = w.write(w.drain(batch))
+			result <- err
+		case <-w.stop:
+			_, err := w.write(w.drain(batch))
+			w.done <- err
+			return
+		}
+	}
+}
+
+// drain moves buffered operations to batch.
+func (w *Writer) drain(batch []Operation) []Operation {
+	for {
+		select {
+		case op := <-w.queue:
+			batch = append(batch, op)
+		default:
+			return batch
+		}
+	}
+}
+
+// write puts batch to db in one transaction and returns emptied batch with
+// error of db. Failed batches are spilled to keep them.
+func (w *Writer) write(batch []Operation) ([]Operation, error) {
+	if len(batch) == 0 {
+		return batch, nil
+	}
+	err := w.db.put(batch)
+	atomic.AddInt64(&w.pending, -int64(len(batch)))
+	if err != nil {
+		logger.Error("Can't write journal operations: " + err.Error())
+		if w.cfg.SpillFile == "" {
+			logger.Error(fmt.Sprintf("%v journal operations are lost", len(batch)))
+		} else if err := w.spill(batch); err != nil {
+			logger.Error("Can't spill journal operations: " + err.Error())
+		}
+	}
+	return batch[:0], err
+}
+
+// spill appends operations to SpillFile as JSON lines.
+func (w *Writer) spill(ops []Operation) error {
+	w.spillMutex.Lock()
+	defer w.spillMutex.Unlock()
+
+	f, err := os.OpenFile(w.cfg.SpillFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, dbMode)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, op := range ops {
+		if err := enc.Encode(op); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// replaySpill writes operations from SpillFile to db and removes it.
+func (w *Writer) replaySpill() error {
+	w.spillMutex.Lock()
+	defer w.spillMutex.Unlock()
+
+	if w.cfg.SpillFile == "" {
+		return nil
+	}
+	f, err := os.Open(w.cfg.SpillFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ops := []Operation{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		op := Operation{}
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			f.Close()
+			return err
+		}
+		ops = append(ops, op)
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if err := w.db.put(ops); err != nil {
+		return err
+	}
+	return os.Remove(w.cfg.SpillFile)
+}
@@ -0,0 +1,143 @@
+package journal_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/geeksteam/ghttp/journal"
+)
+
+func openDB(t *testing.T, dir string, c journal.Journal) *journal.DB {
+	c.BoltDB = filepath.Join(dir, "journal.db")
+	c.BucketForOperations = "Operations"
+	c.Capacity = 60
+	c.DataEncoding = "json"
+	db, err := journal.Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func count(t *testing.T, db *journal.DB) int {
+	ops, err := db.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(ops)
+}
+
+func TestWriterBatches(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "journal")
+	defer os.RemoveAll(dir)
+
+	c := journal.Journal{BufferSize: 10, BatchSize: 3, FlushInterval: 3600}
+	db := openDB(t, dir, c)
+	defer db.Close()
+	w := journal.NewWriter(db, c)
+
+	for _, u := range []string{"a", "b", "c", "d"} {
+		w.Add(journal.Operation{Username: u})
+	}
+	w.Flush()
+	if n := count(t, db); n != 4 {
+		t.Errorf("%v operations written, expected 4", n)
+	}
+
+	w.Add(journal.Operation{Username: "e"})
+	w.Close()
+	if n := count(t, db); n != 5 {
+		t.Errorf("%v operations written after Close, expected 5", n)
+	}
+	if err := w.Add(journal.Operation{Username: "f"}); err == nil {
+		t.Error("Add after Close should fail")
+	}
+}
+
+func TestWriterOverflow(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "journal")
+	defer os.RemoveAll(dir)
+
+	// Buffer of 1 with batches of 1 can't take many operations at once
+	// without blocking, extra ones are dropped or spilled
+	c := journal.Journal{BufferSize: 1, BatchSize: 1, FlushInterval: 3600, Overflow: journal.OverflowSpill, SpillFile: filepath.Join(dir, "spill")}
+	db := openDB(t, dir, c)
+	w := journal.NewWriter(db, c)
+	for _, u := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if err := w.Add(journal.Operation{Username: u}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	written := count(t, db)
+
+	// Spilled operations are written by next writer
+	w = journal.NewWriter(db, c)
+	w.Close()
+	if n := count(t, db); n != 8 {
+		t.Errorf("%v operations written, expected 8 (%v before replay)", n, written)
+	}
+	if _, err := os.Stat(c.SpillFile); !os.IsNotExist(err) {
+		t.Error("Spill file is not removed after replay")
+	}
+	db.Close()
+
+	c.Overflow = journal.OverflowDrop
+	os.Remove(filepath.Join(dir, "journal.db"))
+	db = openDB(t, dir, c)
+	defer db.Close()
+	w = journal.NewWriter(db, c)
+	for _, u := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		w.Add(journal.Operation{Username: u})
+	}
+	w.Close()
+	if n := count(t, db); uint64(n)+w.Dropped() != 8 {
+		t.Errorf("%v operations written and %v dropped, expected 8 total", n, w.Dropped())
+	}
+}
+
+func TestWriterFlushError(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "journal")
+	defer os.RemoveAll(dir)
+
+	c := journal.Journal{BufferSize: 10, BatchSize: 10, FlushInterval: 3600}
+	db := openDB(t, dir, c)
+	w := journal.NewWriter(db, c)
+	defer w.Close()
+
+	w.Add(journal.Operation{Username: "a"})
+	db.Close()
+	if err := w.Flush(); err == nil {
+		t.Error("Flush should return error of failed write")
+	}
+}
+
+func TestDropped(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "journal")
+	defer os.RemoveAll(dir)
+
+	journal.SetConfig(journal.Journal{
+		BoltDB:              filepath.Join(dir, "journal.db"),
+		BucketForOperations: "Operations",
+		Capacity:            60,
+		DataEncoding:        "json",
+		Async:               true,
+		BufferSize:          1,
+		BatchSize:           1,
+		FlushInterval:       3600,
+		Overflow:            journal.OverflowDrop,
+	})
+	defer journal.Close()
+
+	before := journal.Dropped()
+	for _, u := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		journal.Add(journal.Operation{Username: u})
+	}
+	// Dropped operations are counted after default writer is closed too
+	journal.Close()
+	if n := len(journal.GetAll()); uint64(n)+journal.Dropped()-before != 8 {
+		t.Errorf("%v operations written and %v dropped, expected 8 total", n, journal.Dropped()-before)
+	}
+}
@@ -2,11 +2,15 @@ package moduleutils
 
 import "strings"
 
-// GetCurrentModule gets current panel module
+// GetCurrentModule gets current panel module from /api/<module>/... path or
+// URI. Returns empty string if path has no module.
 func GetCurrentModule(path string) string {
-	// Remove /api prefix
-	path = strings.Split(path, "/")[2]
 	// Remove GET paramaters from URI
-	fields := strings.Split(path, "?")
-	return fields[0]
+	path = strings.SplitN(path, "?", 2)[0]
+	// Remove /api prefix
+	fields := strings.Split(strings.Trim(path, "/"), "/")
+	if len(fields) < 2 || fields[0] != "api" {
+		return ""
+	}
+	return fields[1]
 }
@@ -0,0 +1,20 @@
+package moduleutils
+
+import "testing"
+
+func TestGetCurrentModule(t *testing.T) {
+	cases := map[string]string{
+		"/api/dns/list":        "dns",
+		"/api/dns?domain=a.ru": "dns",
+		"/api/mysql/{db}":      "mysql",
+		"/api":                 "",
+		"/":                    "",
+		"":                     "",
+		"/static/app.js":       "",
+	}
+	for path, module := range cases {
+		if m := GetCurrentModule(path); m != module {
+			t.Errorf("GetCurrentModule(%q) = %q, expected %q", path, m, module)
+		}
+	}
+}
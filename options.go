@@ -0,0 +1,90 @@
+package ghttp
+
+import (
+	"github.com/geeksteam/ghttp/bruteforce"
+	"github.com/geeksteam/ghttp/clock"
+	"github.com/geeksteam/ghttp/cors"
+	"github.com/geeksteam/ghttp/hmacauth"
+)
+
+// Option configures Router in NewRouter.
+type Option func(*Router)
+
+// WithGuard sets bruteforce Guard, bruteforce.Default is used by default.
+func WithGuard(guard *bruteforce.Guard) Option {
+	return func(r *Router) {
+		r.Guard = guard
+	}
+}
+
+// WithUsers sets provider of users info for permissions checks.
+func WithUsers(users UserProvider) Option {
+	return func(r *Router) {
+		r.Users = users
+	}
+}
+
+// WithRoles sets provider of users roles for permissions checks.
+func WithRoles(roles RoleProvider) Option {
+	return func(r *Router) {
+		r.Roles = roles
+	}
+}
+
+// WithSecondFactor sets provider of users second factor.
+func WithSecondFactor(provider SecondFactorProvider) Option {
+	return func(r *Router) {
+		r.SecondFactor = provider
+	}
+}
+
+// WithTokens sets provider of API tokens.
+func WithTokens(provider TokenProvider) Option {
+	return func(r *Router) {
+		r.Tokens = provider
+	}
+}
+
+// WithSignatures enables HMAC signed requests verified by authenticator.
+func WithSignatures(authenticator *hmacauth.Authenticator) Option {
+	return func(r *Router) {
+		r.Signatures = authenticator
+	}
+}
+
+// WithCORS sets default CORS policy of routes.
+func WithCORS(policy *cors.Policy) Option {
+	return func(r *Router) {
+		r.CORS = policy
+	}
+}
+
+// WithPostHandlerHook sets hook which runs after every handler.
+func WithPostHandlerHook(hook PostHandlerHook) Option {
+	return func(r *Router) {
+		r.PostHandler = hook
+	}
+}
+
+// WithErrorReporter sets reporter of unknown panics.
+func WithErrorReporter(reporter ErrorReporter) Option {
+	return func(r *Router) {
+		r.Errors = reporter
+	}
+}
+
+// WithLifecycleWatcher sets watcher which tracks running handlers for
+// graceful shutdown.
+func WithLifecycleWatcher(watcher LifecycleWatcher) Option {
+	return func(r *Router) {
+		r.Lifecycle = watcher
+	}
+}
+
+// WithClock sets clock used by router for handlers start time and journal
+// dates. Sessions, Guard and journal have own clocks.
+func WithClock(c clock.Clock) Option {
+	return func(r *Router) {
+		r.clock = c
+	}
+}
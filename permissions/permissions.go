@@ -0,0 +1,95 @@
+package permissions
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Actions and wildcard.
+const (
+	Read  = "read"
+	Write = "write"
+	Any   = "*"
+
+	// AllowAll is a permission which grants every action in every module.
+	AllowAll = "*"
+)
+
+// Permission is a module action, written as "module:action" (dns:read,
+// filemanager:*). Both parts may be "*".
+type Permission struct {
+	Module string
+	Action string
+}
+
+// Parse parses permission string. Module without action means all actions.
+func Parse(s string) (Permission, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Permission{}, fmt.Errorf("Empty permission")
+	}
+	fields := strings.Split(s, ":")
+	switch len(fields) {
+	case 1:
+		return Permission{Module: fields[0], Action: Any}, nil
+	case 2:
+		if fields[0] == "" || fields[1] == "" {
+			return Permission{}, fmt.Errorf("Invalid permission '%v'", s)
+		}
+		return Permission{Module: fields[0], Action: fields[1]}, nil
+	}
+	return Permission{}, fmt.Errorf("Invalid permission '%v'", s)
+}
+
+// String returns permission as "module:action".
+func (p Permission) String() string {
+	return p.Module + ":" + p.Action
+}
+
+// Allows checks if p grants action in module.
+func (p Permission) Allows(module, action string) bool {
+	return (p.Module == Any || p.Module == module) && (p.Action == Any || p.Action == action)
+}
+
+// ActionFor derives action from HTTP method: safe methods read, others write.
+func ActionFor(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return Read
+	}
+	return Write
+}
+
+// Allows checks if any of granted permissions grants action in module. Empty
+// list denies everything, full access should be granted with AllowAll.
+// Invalid permissions are ignored.
+func Allows(granted []string, module, action string) bool {
+	for _, s := range granted {
+		p, err := Parse(s)
+		if err != nil {
+			continue
+		}
+		if p.Allows(module, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers checks if granted permissions grant everything required ones do, so
+// holder of granted doesn't gain access by acting as holder of required.
+// Invalid required permissions grant nothing and are ignored.
+func Covers(granted, required []string) bool {
+	for _, s := range required {
+		p, err := Parse(s)
+		if err != nil {
+			continue
+		}
+		// Wildcards of p are matched literally by wildcards of granted only
+		if !Allows(granted, p.Module, p.Action) {
+			return false
+		}
+	}
+	return true
+}
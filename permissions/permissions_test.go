@@ -0,0 +1,55 @@
+package permissions
+
+import "testing"
+
+func TestAllows(t *testing.T) {
+	granted := []string{"dns:read", "filemanager:*", "*:read"}
+
+	cases := []struct {
+		module, action string
+		allowed        bool
+	}{
+		{"dns", Read, true},
+		{"dns", Write, false},
+		{"filemanager", Write, true},
+		{"mysql", Read, true},
+		{"mysql", Write, false},
+	}
+	for _, c := range cases {
+		if Allows(granted, c.module, c.action) != c.allowed {
+			t.Errorf("Allows(%v:%v) != %v", c.module, c.action, c.allowed)
+		}
+	}
+
+	if Allows(nil, "dns", Read) {
+		t.Error("empty permissions allow access")
+	}
+	if !Allows([]string{AllowAll}, "dns", Write) {
+		t.Error("AllowAll denies access")
+	}
+}
+
+func TestCovers(t *testing.T) {
+	granted := []string{"dns:*", "users:impersonate"}
+
+	cases := []struct {
+		required []string
+		covered  bool
+	}{
+		{[]string{"dns:read"}, true},
+		{[]string{"dns:*", "users:impersonate"}, true},
+		{[]string{"dns:read", "mysql:read"}, false},
+		{[]string{"users:*"}, false},
+		{[]string{"*:read"}, false},
+		{[]string{AllowAll}, false},
+		{nil, true},
+	}
+	for _, c := range cases {
+		if Covers(granted, c.required) != c.covered {
+			t.Errorf("Covers(%v) != %v", c.required, c.covered)
+		}
+	}
+	if !Covers([]string{AllowAll}, []string{AllowAll, "dns:read"}) {
+		t.Error("AllowAll doesn't cover everything")
+	}
+}
@@ -0,0 +1,21 @@
+package ratelimit
+
+type RateLimit struct {
+	Enabled       bool    `default:"false" comment:"Enable token-bucket rate limiting for internal handlers."`
+	Burst         int     `default:"60" comment:"Default bucket size: how much requests may be done in a burst."`
+	Rate          float64 `default:"1" comment:"Default refill rate: how much tokens per second are returned to bucket."`
+	KeyBy         string  `default:"ip" comment:"What buckets are keyed by, comma separated. Values:[ip, user, session, route]"`
+	Backend       string  `default:"memory" comment:"Storage for buckets. Values:[memory, boltdb]"`
+	BoltDBLimits  string  `default:"./db/ratelimit.db" comment:"Path to bolt db for boltdb backend"`
+	BucketLimits  string  `default:"RateLimits" comment:"Name of bucket which holds rate limit buckets"`
+	DataEncoding  string  `default:"mspack" comment:"Encoding of values for boltdb storage. Values:[mspack, json]"`
+	FlushInterval int64   `default:"5" comment:"How often in seconds changed buckets are written to bolt db, limits of last interval are lost on crash"`
+	Rules         map[string]Rule
+}
+
+// Rule overrides default limits for a route pattern (mux path template).
+type Rule struct {
+	Burst int
+	Rate  float64
+	KeyBy string
+}
@@ -0,0 +1,211 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geeksteam/ghttp/clock"
+)
+
+// cleanInterval is how often full buckets are removed from store.
+const cleanInterval = time.Minute
+
+var (
+	cfg   RateLimit
+	store Store = NewMemoryStore()
+	clk         = clock.Real
+	// Local variables
+	lastClean time.Time
+	mutex     sync.Mutex
+)
+
+// SetConfig sets config and opens buckets storage according to Backend.
+// Previous storage is closed first, so the same BoltDB may be reopened.
+func SetConfig(c RateLimit) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cfg = c
+	if err := store.Close(); err != nil {
+		log.Println("Can't close rate limit store:", err)
+	}
+
+	var s Store
+	switch cfg.Backend {
+	case "boltdb":
+		var err error
+		s, err = NewBoltStore(cfg.BoltDBLimits, cfg.BucketLimits, cfg.DataEncoding, time.Duration(cfg.FlushInterval)*time.Second)
+		if err != nil {
+			log.Println("Can't open rate limit db, falling back to memory:", err)
+			s = NewMemoryStore()
+		}
+	default:
+		s = NewMemoryStore()
+	}
+	store = s
+}
+
+// SetStore replaces buckets storage.
+func SetStore(s Store) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	store.Close()
+	store = s
+}
+
+// SetClock sets clock used for buckets refill.
+func SetClock(c clock.Clock) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	clk = c
+}
+
+// Allow takes a token from bucket matching given key. Request should be
+// rejected if Result.Allowed is false.
+func Allow(key Key) Result {
+	mutex.Lock()
+	c, s, now := cfg, store, clk.Now()
+	cleanNeeded := now.Sub(lastClean) > cleanInterval
+	if cleanNeeded {
+		lastClean = now
+	}
+	mutex.Unlock()
+
+	if !c.Enabled {
+		return Result{Allowed: true, Limit: -1}
+	}
+
+	rule := c.rule(key.Route)
+	if cleanNeeded {
+		go clean(s, c, now)
+	}
+
+	result := Result{Limit: rule.Burst}
+	_, ownBucket := c.Rules[key.Route]
+	err := s.Update(rule.key(key, ownBucket), func(b *Bucket) {
+		result = take(b, rule, now)
+	})
+	if err != nil {
+		// Don't block users due to storage problems.
+		log.Println("Rate limit store error:", err)
+		return Result{Allowed: true, Limit: rule.Burst, Remaining: rule.Burst}
+	}
+	return result
+}
+
+// SetHeaders writes RateLimit-* headers and Retry-After for rejected request.
+func (r Result) SetHeaders(w http.ResponseWriter) {
+	if r.Limit < 0 {
+		return
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(r.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(seconds(r.Reset), 10))
+	if !r.Allowed {
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds(r.RetryAfter), 10))
+	}
+}
+
+// take refills bucket for time passed since last update and takes one token.
+func take(b *Bucket, rule Rule, now time.Time) Result {
+	burst := float64(rule.Burst)
+
+	// New bucket is full
+	if b.Updated == 0 {
+		b.Tokens = burst
+	} else if elapsed := now.Sub(time.Unix(0, b.Updated)).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(burst, b.Tokens+elapsed*rule.Rate)
+	}
+	b.Updated = now.UnixNano()
+
+	result := Result{Limit: rule.Burst}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = rule.duration(1 - b.Tokens)
+	}
+	result.Remaining = int(b.Tokens)
+	result.Reset = rule.duration(burst - b.Tokens)
+	return result
+}
+
+// clean removes buckets which are full already, they are the same as absent ones.
+func clean(s Store, c RateLimit, now time.Time) {
+	err := s.Clean(func(b Bucket) bool {
+		// Buckets don't know their rule, so use the slowest refill rate
+		rule := c.slowest()
+		return now.Sub(time.Unix(0, b.Updated)) > rule.duration(float64(rule.Burst))
+	})
+	if err != nil {
+		log.Println("Rate limit store clean error:", err)
+	}
+}
+
+// rule returns limits for route pattern, falling back to defaults.
+func (c RateLimit) rule(route string) Rule {
+	rule, ok := c.Rules[route]
+	if !ok {
+		return Rule{Burst: c.Burst, Rate: c.Rate, KeyBy: c.KeyBy}
+	}
+	if rule.Burst == 0 {
+		rule.Burst = c.Burst
+	}
+	if rule.Rate == 0 {
+		rule.Rate = c.Rate
+	}
+	if rule.KeyBy == "" {
+		rule.KeyBy = c.KeyBy
+	}
+	return rule
+}
+
+// slowest returns rule which takes longest time to refill bucket.
+func (c RateLimit) slowest() Rule {
+	slowest := c.rule("")
+	for route := range c.Rules {
+		if r := c.rule(route); r.duration(float64(r.Burst)) > slowest.duration(float64(slowest.Burst)) {
+			slowest = r
+		}
+	}
+	return slowest
+}
+
+// key builds bucket key from request attributes listed in KeyBy. Routes with
+// own rule get own buckets.
+func (rule Rule) key(k Key, ownBucket bool) string {
+	parts := []string{}
+	for _, field := range strings.Split(rule.KeyBy, ",") {
+		switch strings.TrimSpace(field) {
+		case "ip":
+			parts = append(parts, "ip="+k.IP)
+		case "user":
+			parts = append(parts, "user="+k.Username)
+		case "session":
+			parts = append(parts, "session="+k.SessionID)
+		case "route":
+			parts = append(parts, "route="+k.Route)
+		}
+	}
+	if ownBucket && !strings.Contains(rule.KeyBy, "route") {
+		parts = append(parts, "route="+k.Route)
+	}
+	return strings.Join(parts, "|")
+}
+
+// duration returns time needed to refill given amount of tokens.
+func (rule Rule) duration(tokens float64) time.Duration {
+	if rule.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / rule.Rate * float64(time.Second))
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
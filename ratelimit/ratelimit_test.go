@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	rule := Rule{Burst: 2, Rate: 1}
+	now := time.Unix(1000, 0)
+	b := &Bucket{}
+
+	for i := 0; i < 2; i++ {
+		if r := take(b, rule, now); !r.Allowed {
+			t.Fatalf("request %v rejected within burst", i)
+		}
+	}
+	r := take(b, rule, now)
+	if r.Allowed || r.RetryAfter != time.Second {
+		t.Fatalf("expected rejection with 1s retry, got %+v", r)
+	}
+	if r := take(b, rule, now.Add(time.Second)); !r.Allowed {
+		t.Fatal("bucket not refilled after 1s")
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ratelimit.db")
+
+	s, err := NewBoltStore(path, "RateLimits", "json", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Update("kept", func(b *Bucket) { *b = Bucket{Tokens: 3, Updated: 1} })
+	s.Update("cleaned", func(b *Bucket) { *b = Bucket{Tokens: 1, Updated: 2} })
+	s.Clean(func(b Bucket) bool { return b.Updated == 2 })
+	// Buckets are written on Close at latest
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewBoltStore(path, "RateLimits", "json", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Update("kept", func(b *Bucket) {
+		if b.Tokens != 3 || b.Updated != 1 {
+			t.Errorf("Unexpected saved bucket %+v", b)
+		}
+	})
+	s.Update("cleaned", func(b *Bucket) {
+		if b.Updated != 0 {
+			t.Errorf("Cleaned bucket is saved %+v", b)
+		}
+	})
+}
+
+func TestSetConfigReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := RateLimit{Backend: "boltdb", BoltDBLimits: filepath.Join(dir, "ratelimit.db"), BucketLimits: "RateLimits", DataEncoding: "json"}
+	done := make(chan bool)
+	go func() {
+		SetConfig(c)
+		SetConfig(c)
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetConfig with the same db blocks")
+	}
+	if _, ok := store.(*boltStore); !ok {
+		t.Errorf("Store is not reopened: %T", store)
+	}
+	SetConfig(RateLimit{})
+}
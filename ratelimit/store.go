@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/geeksteam/GoTools/boltdb"
+)
+
+const (
+	dbMode = os.FileMode(0600)
+	// openTimeout limits waiting for file lock held by another process.
+	openTimeout = time.Second
+)
+
+// Store holds buckets. Update must run f atomically for given key, new buckets
+// are passed to f with zero Updated field.
+type Store interface {
+	Update(key string, f func(b *Bucket)) error
+	// Clean removes buckets for which expired returns true.
+	Clean(expired func(b Bucket) bool) error
+	Close() error
+}
+
+// memoryStore keeps buckets in process memory, they are lost on restart.
+type memoryStore struct {
+	buckets map[string]Bucket
+	mutex   sync.Mutex
+}
+
+// NewMemoryStore is a memory Store constructor.
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: make(map[string]Bucket)}
+}
+
+func (s *memoryStore) Update(key string, f func(b *Bucket)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b := s.buckets[key]
+	f(&b)
+	s.buckets[key] = b
+	return nil
+}
+
+func (s *memoryStore) Clean(expired func(b Bucket) bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for k, v := range s.buckets {
+		if expired(v) {
+			delete(s.buckets, k)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// boltStore keeps buckets in memory and writes changed ones to BoltDB in
+// background, so limits survive restarts without a write per request.
+type boltStore struct {
+	db       *bolt.DB
+	bucket   []byte
+	encoding string
+
+	buckets map[string]Bucket
+	dirty   map[string]bool // Changed keys, absent in buckets if deleted
+	mutex   sync.Mutex
+
+	stop chan bool
+	done chan bool
+}
+
+// NewBoltStore opens BoltDB at path, loads buckets from it and returns Store
+// writing changes back every flushInterval.
+func NewBoltStore(path, bucket, encoding string, flushInterval time.Duration) (Store, error) {
+	db, err := bolt.Open(path, dbMode, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+	s := &boltStore{
+		db:       db,
+		bucket:   []byte(bucket),
+		encoding: encoding,
+		buckets:  make(map[string]Bucket),
+		dirty:    make(map[string]bool),
+		stop:     make(chan bool),
+		done:     make(chan bool),
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(s.bucket)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			b := Bucket{}
+			// Undecodable buckets are the same as full ones
+			if boltdb.DecodeValue(v, &b, s.encoding) == nil {
+				s.buckets[string(k)] = b
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+	go s.run(flushInterval)
+	return s, nil
+}
+
+func (s *boltStore) Update(key string, f func(b *Bucket)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b := s.buckets[key]
+	f(&b)
+	s.buckets[key] = b
+	s.dirty[key] = true
+	return nil
+}
+
+func (s *boltStore) Clean(expired func(b Bucket) bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for k, v := range s.buckets {
+		if expired(v) {
+			delete(s.buckets, k)
+			s.dirty[k] = true
+		}
+	}
+	return nil
+}
+
+// Close writes changed buckets and closes BoltDB.
+func (s *boltStore) Close() error {
+	s.stop <- true
+	<-s.done
+	err := s.flush()
+	if errClose := s.db.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func (s *boltStore) run(flushInterval time.Duration) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				log.Println("Can't save rate limit buckets:", err)
+			}
+		case <-s.stop:
+			s.done <- true
+			return
+		}
+	}
+}
+
+// flush writes buckets changed since last flush in one transaction.
+func (s *boltStore) flush() error {
+	// Copy changes, nil values are deleted buckets
+	s.mutex.Lock()
+	changes := make(map[string]*Bucket, len(s.dirty))
+	for k := range s.dirty {
+		if b, ok := s.buckets[k]; ok {
+			changes[k] = &b
+		} else {
+			changes[k] = nil
+		}
+	}
+	s.dirty = make(map[string]bool)
+	s.mutex.Unlock()
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.bucket)
+		for k, b := range changes {
+			if b == nil {
+				if err := bucket.Delete([]byte(k)); err != nil {
+					return err
+				}
+				continue
+			}
+			value, err := boltdb.EncodeValue(*b, s.encoding)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(k), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
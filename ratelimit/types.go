@@ -0,0 +1,26 @@
+package ratelimit
+
+import "time"
+
+// Bucket is a token bucket state.
+type Bucket struct {
+	Tokens  float64 // Tokens left in bucket
+	Updated int64   // Unixtime in nanoseconds of last refill
+}
+
+// Key holds request's attributes buckets could be keyed by.
+type Key struct {
+	IP        string
+	Username  string
+	SessionID string
+	Route     string // Route pattern (/api/dns/{domain})
+}
+
+// Result is a rate limit decision for a single request.
+type Result struct {
+	Allowed    bool
+	Limit      int           // Bucket size
+	Remaining  int           // Tokens left after this request
+	Reset      time.Duration // Time until bucket is full again
+	RetryAfter time.Duration // Time until next token, set only if not allowed
+}
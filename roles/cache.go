@@ -0,0 +1,51 @@
+package roles
+
+import "sync"
+
+// cache keeps roles of users by db, so permissions checks don't open db on
+// every request. User's roles are forgotten on Actualizer notification about
+// their change, all roles are forgotten on change of any role.
+var (
+	cache      = map[Roles]map[string][]Role{}
+	cacheGen   uint64 // Changed on every forget, so roles read before it aren't cached
+	cacheMutex sync.RWMutex
+)
+
+// cached returns roles of user from cache and cache generation for remember.
+func (r Roles) cached(username string) ([]Role, uint64, bool) {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	roles, ok := cache[r][username]
+	return append([]Role{}, roles...), cacheGen, ok
+}
+
+// remember caches roles of user, which were read from db at generation gen.
+func (r Roles) remember(username string, roles []Role, gen uint64) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if gen != cacheGen {
+		return
+	}
+	if cache[r] == nil {
+		cache[r] = map[string][]Role{}
+	}
+	cache[r][username] = roles
+}
+
+// forget removes user's roles from cache of every db.
+func forget(username string) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	cacheGen++
+	for _, users := range cache {
+		delete(users, username)
+	}
+}
+
+// forgetAll clears cache of db, roles of any user may have changed.
+func (r Roles) forgetAll() {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	cacheGen++
+	delete(cache, r)
+}
@@ -0,0 +1,8 @@
+package roles
+
+type Roles struct {
+	BoltDBRoles           string `default:"./db/main.db" comment:"Path to db with roles, main db by default"`
+	BoltDBRolesBucket     string `default:"Roles" comment:"Name of roles bucket in main db"`
+	BoltDBUserRolesBucket string `default:"UserRoles" comment:"Name of bucket with roles of users in main db"`
+	DataEncoding          string `default:"mspack" comment:"Encoding of values for boltdb storage. Values:[mspack, json]"`
+}
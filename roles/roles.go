@@ -0,0 +1,162 @@
+package roles
+
+import (
+	"log"
+
+	"github.com/geeksteam/GoTools/boltdb"
+	"github.com/geeksteam/ghttp/sessions"
+)
+
+// Set Creates new or update role. Users holding the role are notified at once.
+func (r Roles) Set(role Role) {
+	boltdb.DB(r.BoltDBRoles, r.DataEncoding).Bucket(r.BoltDBRolesBucket).Set(role.Name, role)
+	r.changed(role.Name)
+}
+
+// Delete Deletes role from db. Users keep role name, but it grants nothing.
+// Users holding the role are notified at once.
+func (r Roles) Delete(name string) {
+	boltdb.DB(r.BoltDBRoles, r.DataEncoding).Bucket(r.BoltDBRolesBucket).Delete(name)
+	r.changed(name)
+}
+
+// changed forgets cached roles and notifies users holding changed role.
+func (r Roles) changed(name string) {
+	r.forgetAll()
+
+	users, err := r.UsersWithRole(name)
+	if err != nil {
+		log.Println("Can't get users with role:", err)
+		return
+	}
+	for username, roles := range users {
+		actualize(username, roles)
+	}
+}
+
+// Get Returns role with given name
+func (r Roles) Get(name string) *Role {
+	role := &Role{}
+	err := boltdb.DB(r.BoltDBRoles, r.DataEncoding).Bucket(r.BoltDBRolesBucket).Get(name, role)
+	if err != nil {
+		return nil
+	}
+	return role
+}
+
+// GetAll Get all roles with their names
+func (r Roles) GetAll() (map[string]Role, error) {
+	roles := map[string]Role{}
+
+	all, err := boltdb.DB(r.BoltDBRoles, r.DataEncoding).Bucket(r.BoltDBRolesBucket).GetAll(&Role{})
+	if err != nil {
+		return roles, err
+	}
+
+	for k, v := range all {
+		roles[k] = *v.(*Role)
+	}
+	return roles, nil
+}
+
+// SetUserRoles sets roles of user. Live sessions get new permissions on next
+// request and GUI is notified through Actualizer.
+func (r Roles) SetUserRoles(username string, roles []string) {
+	boltdb.DB(r.BoltDBRoles, r.DataEncoding).Bucket(r.BoltDBUserRolesBucket).Set(username, roles)
+	actualize(username, roles)
+}
+
+// GetUserRoles returns role names of user.
+func (r Roles) GetUserRoles(username string) []string {
+	roles := []string{}
+	if err := boltdb.DB(r.BoltDBRoles, r.DataEncoding).Bucket(r.BoltDBUserRolesBucket).Get(username, &roles); err != nil {
+		return []string{}
+	}
+	return roles
+}
+
+// UserRoles returns roles of user with their permissions. Unknown role names
+// are skipped. Roles are cached until they change.
+func (r Roles) UserRoles(username string) ([]Role, error) {
+	result, gen, ok := r.cached(username)
+	if ok {
+		return result, nil
+	}
+
+	result = []Role{}
+	for _, name := range r.GetUserRoles(username) {
+		if role := r.Get(name); role != nil {
+			result = append(result, *role)
+		}
+	}
+	r.remember(username, result, gen)
+	return result, nil
+}
+
+// UsersWithRole returns users holding role with all their roles.
+func (r Roles) UsersWithRole(name string) (map[string][]string, error) {
+	all, err := boltdb.DB(r.BoltDBRoles, r.DataEncoding).Bucket(r.BoltDBUserRolesBucket).GetAll(&[]string{})
+	if err != nil {
+		return nil, err
+	}
+
+	users := map[string][]string{}
+	for username, v := range all {
+		roles := *v.(*[]string)
+		if Has(roles, name) {
+			users[username] = roles
+		}
+	}
+	return users, nil
+}
+
+// EnsureDefaults creates missing built-in roles and makes "root" user a holder
+// of Root role, if the user has no roles yet.
+func (r Roles) EnsureDefaults() {
+	for _, role := range Defaults {
+		if r.Get(role.Name) == nil {
+			r.Set(role)
+		}
+	}
+	if len(r.GetUserRoles("root")) == 0 {
+		r.SetUserRoles("root", []string{Root})
+	}
+}
+
+// Has checks if role names contain given one.
+func Has(roles []string, name string) bool {
+	for _, role := range roles {
+		if role == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns names of roles.
+func Names(roles []Role) []string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+// Permissions returns all permissions granted by roles.
+func Permissions(roles []Role) []string {
+	permissions := []string{}
+	for _, role := range roles {
+		permissions = append(permissions, role.Permissions...)
+	}
+	return permissions
+}
+
+// actualize forgets cached roles of user and notifies user's sessions about
+// their change.
+func actualize(username string, roles []string) {
+	forget(username)
+	if sessions.SessionsStorage == nil {
+		return
+	}
+	sessions.SessionsStorage.Actualize(username, RolesChanged{Type: "RolesChanged", Roles: roles})
+}
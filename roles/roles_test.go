@@ -0,0 +1,51 @@
+package roles_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/geeksteam/ghttp/roles"
+)
+
+func TestUserRolesCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := roles.Roles{
+		BoltDBRoles:           filepath.Join(dir, "main.db"),
+		BoltDBRolesBucket:     "Roles",
+		BoltDBUserRolesBucket: "UserRoles",
+		DataEncoding:          "json",
+	}
+
+	r.Set(roles.Role{Name: "dns", Permissions: []string{"dns:read"}})
+	r.SetUserRoles("bob", []string{"dns"})
+	assertPermissions(t, r, "bob", []string{"dns:read"})
+
+	// Cached roles are forgotten on change
+	r.Set(roles.Role{Name: "dns", Permissions: []string{"dns:*"}})
+	assertPermissions(t, r, "bob", []string{"dns:*"})
+
+	r.SetUserRoles("bob", []string{"dns", roles.Support})
+	r.Set(roles.Role{Name: roles.Support, Permissions: []string{"*:read"}})
+	assertPermissions(t, r, "bob", []string{"dns:*", "*:read"})
+
+	r.Delete("dns")
+	assertPermissions(t, r, "bob", []string{"*:read"})
+}
+
+func assertPermissions(t *testing.T, r roles.Roles, username string, expected []string) {
+	t.Helper()
+	userRoles, err := r.UserRoles(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if permissions := roles.Permissions(userRoles); !reflect.DeepEqual(permissions, expected) {
+		t.Errorf("Permissions of %v are %v, expected %v", username, permissions, expected)
+	}
+}
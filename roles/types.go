@@ -0,0 +1,33 @@
+package roles
+
+// Built-in roles. Root grants everything without any other checks.
+const (
+	Root     = "root"
+	Admin    = "admin"
+	Reseller = "reseller"
+	Support  = "support"
+	User     = "user"
+)
+
+// Role is a named set of permissions like "dns:read", "filemanager:*".
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// RolesChanged is sent to Actualizer of users whose roles have changed, so
+// GUI can reload permissions.
+type RolesChanged struct {
+	Type  string // Always "RolesChanged"
+	Roles []string
+}
+
+// Defaults are built-in roles created by EnsureDefaults.
+var Defaults = []Role{
+	{Name: Root, Description: "Full access without checks", Permissions: []string{"*"}},
+	{Name: Admin, Description: "Full access", Permissions: []string{"*"}},
+	{Name: Reseller, Description: "Manages own users", Permissions: []string{"users:*", "utemplates:*", "*:read"}},
+	{Name: Support, Description: "Read-only access to everything", Permissions: []string{"*:read"}},
+	{Name: User, Description: "Access granted by user's template"},
+}
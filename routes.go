@@ -0,0 +1,228 @@
+package ghttp
+
+import (
+	"sort"
+
+	"github.com/geeksteam/ghttp/cors"
+	"github.com/geeksteam/ghttp/moduleutils"
+	"github.com/geeksteam/ghttp/permissions"
+	"github.com/gorilla/mux"
+)
+
+// Route kinds.
+const (
+	KindInternal = "internal"
+	KindLogin    = "login"
+)
+
+// Route is a gorilla's Route wrapper, which declares module and permissions
+// required to access it, like router.HandleInternalFunc(...).Require("mysql:write").
+// Common matchers return *Route, so calls chain in any order; other mux.Route
+// methods return *mux.Route and should come last.
+type Route struct {
+	*mux.Route
+	router *Router
+
+	kind        string
+	path        string
+	module      string   // Module from path if not declared
+	permissions []string // Required permissions, action derived from method if empty
+	anyUser     bool     // Any logged in user may access
+	stepUp      bool     // Recent authentication is required
+	cors        *cors.Policy
+}
+
+// RouteInfo describes route for introspection.
+type RouteInfo struct {
+	Kind        string
+	Path        string // Path template
+	Methods     []string
+	Module      string
+	Permissions []string // Declared permissions, empty if derived from method
+	AnyUser     bool     // Only session is required
+	StepUp      bool     // Recent authentication is required
+}
+
+// newRoute registers route in router.
+func (router *Router) newRoute(kind, path string, route *mux.Route) *Route {
+	rt := &Route{
+		Route:  route,
+		router: router,
+		kind:   kind,
+		path:   path,
+		module: moduleutils.GetCurrentModule(path),
+	}
+
+	router.mutex.Lock()
+	router.routes = append(router.routes, rt)
+	router.mutex.Unlock()
+	return rt
+}
+
+// Module declares route's module instead of one from path.
+func (rt *Route) Module(module string) *Route {
+	rt.router.mutex.Lock()
+	defer rt.router.mutex.Unlock()
+	rt.module = module
+	return rt
+}
+
+// Require declares permissions required to access route, all of them should be
+// granted. Action is derived from HTTP method for routes without them.
+func (rt *Route) Require(required ...string) *Route {
+	for _, p := range required {
+		if _, err := permissions.Parse(p); err != nil {
+			panic(err)
+		}
+	}
+
+	rt.router.mutex.Lock()
+	defer rt.router.mutex.Unlock()
+	rt.permissions = append(rt.permissions, required...)
+	return rt
+}
+
+// AnyUser lets any logged in user access route without permissions checks,
+// e.g. to return from impersonation.
+func (rt *Route) AnyUser() *Route {
+	rt.router.mutex.Lock()
+	defer rt.router.mutex.Unlock()
+	rt.anyUser = true
+	return rt
+}
+
+// StepUp requires authentication not older than StepUpTime to access route,
+// e.g. to delete user. Otherwise response is 401 with AuthRequiredHeader set
+// to AuthStepUp and client should reauthenticate with HandleStepUp. API tokens
+// never pass it.
+func (rt *Route) StepUp() *Route {
+	rt.router.mutex.Lock()
+	defer rt.router.mutex.Unlock()
+	rt.stepUp = true
+	return rt
+}
+
+// CORS sets CORS policy of route instead of router's one.
+func (rt *Route) CORS(policy *cors.Policy) *Route {
+	rt.router.mutex.Lock()
+	defer rt.router.mutex.Unlock()
+	rt.cors = policy
+	return rt
+}
+
+// Methods adds matcher for HTTP methods, see mux.Route.Methods.
+func (rt *Route) Methods(methods ...string) *Route {
+	rt.Route.Methods(methods...)
+	return rt
+}
+
+// Name sets route name, see mux.Route.Name.
+func (rt *Route) Name(name string) *Route {
+	rt.Route.Name(name)
+	return rt
+}
+
+// Queries adds matcher for URL query values, see mux.Route.Queries.
+func (rt *Route) Queries(pairs ...string) *Route {
+	rt.Route.Queries(pairs...)
+	return rt
+}
+
+// Headers adds matcher for request header values, see mux.Route.Headers.
+func (rt *Route) Headers(pairs ...string) *Route {
+	rt.Route.Headers(pairs...)
+	return rt
+}
+
+// corsPolicy returns CORS policy of route, router's one if route has none.
+func (rt *Route) corsPolicy() *cors.Policy {
+	rt.router.mutex.RLock()
+	defer rt.router.mutex.RUnlock()
+	if rt.cors != nil {
+		return rt.cors
+	}
+	return rt.router.corsDefault()
+}
+
+// Timeout sets timeout in seconds between runs of route's handler for single
+// user. Route's methods should be set already.
+func (rt *Route) Timeout(seconds int64) *Route {
+	rt.router.Timeout(rt.Route, seconds)
+	return rt
+}
+
+// Info returns route description.
+func (rt *Route) Info() RouteInfo {
+	rt.router.mutex.RLock()
+	defer rt.router.mutex.RUnlock()
+	return rt.info()
+}
+
+func (rt *Route) info() RouteInfo {
+	methods, _ := rt.GetMethods()
+	return RouteInfo{
+		Kind:        rt.kind,
+		Path:        rt.path,
+		Methods:     methods,
+		Module:      rt.module,
+		Permissions: append([]string{}, rt.permissions...),
+		AnyUser:     rt.anyUser,
+		StepUp:      rt.stepUp,
+	}
+}
+
+// Routes lists all routes registered with HandleInternalFunc and
+// HandleLoginFunc sorted by path.
+func (router *Router) Routes() []RouteInfo {
+	router.mutex.RLock()
+	defer router.mutex.RUnlock()
+
+	result := make([]RouteInfo, 0, len(router.routes))
+	for _, rt := range router.routes {
+		result = append(result, rt.info())
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// RoutesFor lists routes which user may access, so GUI can hide the rest.
+// Routes without methods are listed if any action is allowed.
+func (router *Router) RoutesFor(username string) []RouteInfo {
+	granted, root := router.userPermissions(username)
+
+	result := []RouteInfo{}
+	for _, info := range router.Routes() {
+		if root || info.Kind == KindLogin || info.AnyUser || info.allows(granted) {
+			result = append(result, info)
+		}
+	}
+	return result
+}
+
+// allows checks if granted permissions allow any of route's methods.
+func (info RouteInfo) allows(granted []string) bool {
+	methods := info.Methods
+	if len(methods) == 0 {
+		methods = []string{"GET", "POST"}
+	}
+	for _, method := range methods {
+		if info.allowsMethod(granted, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsMethod checks if granted permissions allow route with method.
+func (info RouteInfo) allowsMethod(granted []string, method string) bool {
+	if len(info.Permissions) == 0 {
+		return permissions.Allows(granted, info.Module, permissions.ActionFor(method))
+	}
+	for _, required := range info.Permissions {
+		p, _ := permissions.Parse(required)
+		if !permissions.Allows(granted, p.Module, p.Action) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,16 @@
+package secheaders
+
+type SecurityHeaders struct {
+	HSTSMaxAge            int    `default:"31536000" comment:"Strict-Transport-Security max-age, 0 disables header. Seconds."`
+	HSTSIncludeSubdomains bool   `default:"true" comment:"Add includeSubDomains to Strict-Transport-Security"`
+	HSTSPreload           bool   `default:"false" comment:"Add preload to Strict-Transport-Security"`
+	FrameOptions          string `default:"DENY" comment:"X-Frame-Options, empty disables header. Values:[DENY, SAMEORIGIN]"`
+	ContentTypeNosniff    bool   `default:"true" comment:"Send X-Content-Type-Options: nosniff"`
+	PermissionsPolicy     string `default:"camera=(), microphone=(), geolocation=(), payment=()" comment:"Permissions-Policy, empty disables header"`
+
+	APIContentSecurityPolicy string `default:"default-src 'none'; frame-ancestors 'none'" comment:"Content-Security-Policy of API responses, {nonce} is replaced with per-request nonce"`
+	APIReferrerPolicy        string `default:"no-referrer" comment:"Referrer-Policy of API responses"`
+
+	StaticContentSecurityPolicy string `default:"default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'" comment:"Content-Security-Policy of static responses, {nonce} is replaced with per-request nonce"`
+	StaticReferrerPolicy        string `default:"strict-origin-when-cross-origin" comment:"Referrer-Policy of static responses"`
+}
@@ -0,0 +1,104 @@
+// Package secheaders sets security headers of responses. API and static
+// responses have own Content-Security-Policy and Referrer-Policy. Policies
+// may use per-request nonce, which templates get with Nonce.
+package secheaders
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kinds of responses.
+const (
+	API    = "api"
+	Static = "static"
+)
+
+// nonceHolder is a CSP nonce placeholder in policies.
+const nonceHolder = "{nonce}"
+
+type contextKey struct{}
+
+var (
+	cfg   SecurityHeaders
+	mutex sync.RWMutex
+)
+
+// SetConfig sets config.
+func SetConfig(c SecurityHeaders) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	cfg = c
+}
+
+// Apply sets security headers of kind and returns request with CSP nonce in
+// context if policy uses it.
+func Apply(w http.ResponseWriter, r *http.Request, kind string) *http.Request {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	csp, referrer := cfg.APIContentSecurityPolicy, cfg.APIReferrerPolicy
+	if kind == Static {
+		csp, referrer = cfg.StaticContentSecurityPolicy, cfg.StaticReferrerPolicy
+	}
+
+	if strings.Contains(csp, nonceHolder) {
+		nonce := newNonce()
+		csp = strings.Replace(csp, nonceHolder, nonce, -1)
+		r = r.WithContext(context.WithValue(r.Context(), contextKey{}, nonce))
+	}
+
+	h := w.Header()
+	set(h, "Content-Security-Policy", csp)
+	set(h, "Referrer-Policy", referrer)
+	set(h, "X-Frame-Options", cfg.FrameOptions)
+	set(h, "Permissions-Policy", cfg.PermissionsPolicy)
+	if cfg.ContentTypeNosniff {
+		h.Set("X-Content-Type-Options", "nosniff")
+	}
+	if cfg.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.Itoa(cfg.HSTSMaxAge)
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if cfg.HSTSPreload {
+			hsts += "; preload"
+		}
+		h.Set("Strict-Transport-Security", hsts)
+	}
+	return r
+}
+
+// Handler wraps static files handler, like
+// secheaders.Handler(secheaders.Static, handlerUtils.MakeGzipHandler(...)).
+func Handler(kind string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, Apply(w, r, kind))
+	})
+}
+
+// Nonce returns CSP nonce of request for script and style tags, empty if
+// policy doesn't use it.
+func Nonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(contextKey{}).(string)
+	return nonce
+}
+
+func set(h http.Header, key, value string) {
+	if value != "" {
+		h.Set(key, value)
+	}
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
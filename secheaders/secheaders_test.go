@@ -0,0 +1,51 @@
+package secheaders_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/geeksteam/ghttp/secheaders"
+)
+
+func TestHandler(t *testing.T) {
+	secheaders.SetConfig(secheaders.SecurityHeaders{
+		HSTSMaxAge:                  600,
+		HSTSIncludeSubdomains:       true,
+		FrameOptions:                "DENY",
+		ContentTypeNosniff:          true,
+		APIContentSecurityPolicy:    "default-src 'none'",
+		StaticContentSecurityPolicy: "script-src 'nonce-{nonce}'",
+		StaticReferrerPolicy:        "same-origin",
+	})
+
+	var nonce string
+	h := secheaders.Handler(secheaders.Static, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = secheaders.Nonce(r.Context())
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/index.html", nil))
+
+	if nonce == "" || w.Header().Get("Content-Security-Policy") != "script-src 'nonce-"+nonce+"'" {
+		t.Errorf("Nonce %q doesn't match policy %q", nonce, w.Header().Get("Content-Security-Policy"))
+	}
+	if w.Header().Get("Strict-Transport-Security") != "max-age=600; includeSubDomains" ||
+		w.Header().Get("X-Frame-Options") != "DENY" || w.Header().Get("Referrer-Policy") != "same-origin" ||
+		w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("Unexpected headers %v", w.Header())
+	}
+
+	// Nonces differ between requests
+	first := nonce
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/index.html", nil))
+	if first == nonce {
+		t.Error("Nonce is reused")
+	}
+
+	w = httptest.NewRecorder()
+	r := secheaders.Apply(w, httptest.NewRequest("GET", "/api/dns/list", nil), secheaders.API)
+	if secheaders.Nonce(r.Context()) != "" || strings.Contains(w.Header().Get("Content-Security-Policy"), "nonce") {
+		t.Errorf("API policy without nonce got one: %v", w.Header())
+	}
+}
@@ -0,0 +1,23 @@
+package sessions
+
+import "context"
+
+type contextKey struct{}
+
+// Kinds of authentication of sessions without cookie.
+const (
+	AuthToken = "token" // API token from Authorization header
+	AuthHMAC  = "hmac"  // HMAC signed server-to-server request
+)
+
+// NewContext returns context with session of request authenticated without
+// cookie, Get returns it for such requests.
+func NewContext(ctx context.Context, sess *Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, sess)
+}
+
+// FromContext returns session stored by NewContext.
+func FromContext(ctx context.Context) (*Session, bool) {
+	sess, ok := ctx.Value(contextKey{}).(*Session)
+	return sess, ok
+}
@@ -0,0 +1,92 @@
+package sessions
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/geeksteam/GoTools/deepcopy"
+	"github.com/geeksteam/GoTools/stringutils"
+)
+
+var (
+	errNestedImpersonation = errors.New("Can't impersonate from impersonated session.")
+	errNotImpersonated     = errors.New("Session is not impersonated.")
+	errImpersonatorGone    = errors.New("Impersonator's session has expired, login again.")
+	errCookieRequired      = errors.New("Impersonation requires login session.")
+)
+
+// StartImpersonation creates session of targetUser on behalf of admin, who
+// owns adminSession, and writes its cookie into response. Admin's session is
+// kept to return to it with StopImpersonation. Impersonated session lives at
+// most ImpersonationLifeTime seconds, if it's set.
+func (s *Sessions) StartImpersonation(r *http.Request, w http.ResponseWriter, adminSession *Session, targetUser string) (*Session, error) {
+	if adminSession.Impersonator != "" {
+		return nil, errNestedImpersonation
+	}
+	if adminSession.Auth != "" {
+		return nil, errCookieRequired
+	}
+
+	sessionID := stringutils.GetRandomString(cfg.SessionIDKeyLength)
+	http.SetCookie(w, &http.Cookie{Name: cfg.SessionIDKey, Value: sessionID, Path: "/"})
+
+	now := s.now().Unix()
+	sess := Session{
+		ID:           sessionID,
+		IP:           clientIP(r),
+		Username:     targetUser,
+		UserAgent:    r.UserAgent(),
+		Created:      now,
+		LastActivity: now,
+		AuthTime:     0, // Step-up needs user's own second factor, which admin hasn't
+		CSRFToken:    newCSRFToken(),
+		Theme:        adminSession.Theme,
+		Language:     adminSession.Language,
+		Actualizer: &ActualizeListener{
+			MessageChan: make(chan interface{}, 10),
+			CloseChan:   make(chan bool, 10),
+			IsListening: false,
+		},
+
+		LastHandlers: make(map[string]int64),
+
+		Impersonator:          adminSession.Username,
+		ImpersonatorSessionID: adminSession.ID,
+	}
+
+	if cfg.ImpersonationLifeTime > 0 {
+		sess.ExpiresAt = now + int64(cfg.ImpersonationLifeTime)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.sessions[sessionID] = sess
+	return deepcopy.Iface(&sess).(*Session), nil
+}
+
+// StopImpersonation deletes impersonated session of request and returns
+// impersonator to own session, writing its cookie into response.
+func (s *Sessions) StopImpersonation(r *http.Request, w http.ResponseWriter) (*Session, error) {
+	sess, err := s.Get(r)
+	if err != nil {
+		return nil, err
+	}
+	if sess.Impersonator == "" {
+		return nil, errNotImpersonated
+	}
+	s.DelByID(sess.ID)
+
+	s.Lock()
+	defer s.Unlock()
+
+	admin, ok := s.sessions[sess.ImpersonatorSessionID]
+	if !ok {
+		return nil, errImpersonatorGone
+	}
+	admin.LastActivity = s.now().Unix()
+	s.sessions[admin.ID] = admin
+
+	http.SetCookie(w, &http.Cookie{Name: cfg.SessionIDKey, Value: admin.ID, Path: "/"})
+	return deepcopy.Iface(&admin).(*Session), nil
+}
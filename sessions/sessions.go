@@ -1,16 +1,19 @@
 package sessions
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/geeksteam/GoTools/deepcopy"
 	"github.com/geeksteam/GoTools/stringutils"
+	"github.com/geeksteam/ghttp/clock"
 )
 
 var (
@@ -53,21 +56,53 @@ type Session struct {
 	Actualizer *ActualizeListener `json:"-"`
 
 	LastHandlers map[string]int64 // /handler and unixtime of last request
+
+	Impersonator          string // Имя админа, вошедшего под этим юзером
+	ImpersonatorSessionID string // Сессия админа, в которую он вернется
+	ExpiresAt             int64  // Unixtime, после которого сессия удаляется независимо от активности (0 - нет ограничения)
+
+	SecondFactorPending bool  // Пароль принят, ждем второй фактор
+	AuthTime            int64 // Unixtime последней аутентификации, для step-up проверок
+
+	CSRFToken string // Токен против CSRF для запросов с cookie
+
+	Auth   string   // Способ аутентификации без cookie (AuthToken, AuthHMAC), пусто для cookie
+	Scopes []string // Ограничение прав для Auth сессий, права юзера не расширяет
 }
 
 // Sessions is a general service, which handles sessions.
 type Sessions struct {
 	sessions map[string]Session // Список сессий
+	clock    clock.Clock        // Часы для времени активности и истечения сессий
 	sync.RWMutex
 }
 
 // NewSessions is a Sessions constructor.
 func NewSessions() {
-	SessionsStorage = &Sessions{make(map[string]Session), sync.RWMutex{}}
+	SessionsStorage = &Sessions{sessions: make(map[string]Session), clock: clock.Real}
+}
+
+// SetClock sets clock used for sessions activity and expiry.
+func (s *Sessions) SetClock(c clock.Clock) {
+	s.Lock()
+	defer s.Unlock()
+	s.clock = c
+}
+
+// now returns current time by sessions clock.
+func (s *Sessions) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
 }
 
 // Get attempts to get session from local sessions map.
 func (s *Sessions) Get(r *http.Request) (*Session, error) {
+	// Session authenticated without cookie
+	if sess, ok := FromContext(r.Context()); ok {
+		return deepcopy.Iface(sess).(*Session), nil
+	}
 
 	// Getting SessID from cookie
 	cookie, err := r.Cookie(cfg.SessionIDKey)
@@ -88,7 +123,7 @@ func (s *Sessions) Get(r *http.Request) (*Session, error) {
 
 	// Check session IP and client IP if StrictIP on
 	if cfg.StrictIP {
-		if sess.IP != strings.Split(r.RemoteAddr, ":")[0] {
+		if sess.IP != clientIP(r) {
 			return nil, errIP
 		}
 	}
@@ -156,11 +191,17 @@ func (s *Sessions) RegisterActivity(r *http.Request) error {
 		return fmt.Errorf("No session with id %v found", sessionID)
 	}
 
-	sess.LastActivity = time.Now().Unix()
-	sess.LastHandlers[r.RequestURI] = time.Now().Unix()
+	sess.LastActivity = s.now().Unix()
+	sess.LastHandlers[r.RequestURI] = s.now().Unix()
 
 	s.sessions[sessionID] = sess
 
+	// Keep impersonator's session alive to return to it
+	if admin, ok := s.sessions[sess.ImpersonatorSessionID]; ok && sess.Impersonator != "" {
+		admin.LastActivity = sess.LastActivity
+		s.sessions[admin.ID] = admin
+	}
+
 	return nil
 }
 
@@ -173,12 +214,14 @@ func (s *Sessions) StartNewSession(r *http.Request, w http.ResponseWriter, usern
 
 	sess := Session{
 		ID:        sessionID,
-		IP:        strings.Split(r.RemoteAddr, ":")[0],
+		IP:        clientIP(r),
 		Username:  username,
 		UserAgent: r.UserAgent(),
 		// 	UserInfo:     users.Get(username),
-		Created:      time.Now().Unix(),
-		LastActivity: time.Now().Unix(),
+		Created:      s.now().Unix(),
+		LastActivity: s.now().Unix(),
+		AuthTime:     s.now().Unix(),
+		CSRFToken:    newCSRFToken(),
 		Actualizer: &ActualizeListener{
 			MessageChan: make(chan interface{}, 10),
 			CloseChan:   make(chan bool, 10),
@@ -196,6 +239,42 @@ func (s *Sessions) StartNewSession(r *http.Request, w http.ResponseWriter, usern
 	return deepcopy.Iface(&sess).(*Session)
 }
 
+// StartPendingSession starts session of user who passed password check but
+// should pass second factor with Reauthenticated before session is usable.
+func (s *Sessions) StartPendingSession(r *http.Request, w http.ResponseWriter, username string) *Session {
+	sess := s.StartNewSession(r, w, username)
+
+	s.Lock()
+	defer s.Unlock()
+
+	stored := s.sessions[sess.ID]
+	stored.SecondFactorPending = true
+	s.sessions[sess.ID] = stored
+	sess.SecondFactorPending = true
+	return sess
+}
+
+// Reauthenticated marks session of request as just authenticated, it clears
+// pending second factor and renews AuthTime for step-up routes.
+func (s *Sessions) Reauthenticated(r *http.Request) error {
+	sess, err := s.Get(r)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	stored, ok := s.sessions[sess.ID]
+	if !ok {
+		return errNoSessionWithID
+	}
+	stored.SecondFactorPending = false
+	stored.AuthTime = s.now().Unix()
+	s.sessions[sess.ID] = stored
+	return nil
+}
+
 // Set attempts to reset current user's session struct to given session struct.
 func (s *Sessions) Set(r *http.Request, session Session) error {
 	s.RLock()
@@ -224,7 +303,9 @@ func (s *Sessions) CleanExpired() {
 		// if time.Now().Unix()-v.LastActivity >= int64(cfg.SessionLifeTime) {
 		// 	delete(s.sessions, k)
 		// }
-		if time.Now().After(time.Unix(v.LastActivity, 0).Add(time.Duration(cfg.SessionLifeTime) * time.Second)) {
+		if s.now().After(time.Unix(v.LastActivity, 0).Add(time.Duration(cfg.SessionLifeTime) * time.Second)) {
+			sessForKill = append(sessForKill, k)
+		} else if v.ExpiresAt > 0 && s.now().Unix() >= v.ExpiresAt {
 			sessForKill = append(sessForKill, k)
 		}
 	}
@@ -291,3 +372,21 @@ func (s *Sessions) Actualize(username string, message interface{}) {
 		}
 	}
 }
+
+// newCSRFToken generates random CSRF token of session.
+func newCSRFToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// clientIP returns client IP without port, IPv6 addresses are supported.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,32 @@
+package sessions_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/geeksteam/ghttp/ghttptest"
+	"github.com/geeksteam/ghttp/sessions"
+)
+
+func TestCleanExpired(t *testing.T) {
+	sessions.SetConfig(sessions.SessionsConf{SessionIDKey: "sessionID", SessionIDKeyLength: 24, SessionLifeTime: 1800})
+	sessions.NewSessions()
+	clock := ghttptest.NewFakeClock(time.Unix(1500000000, 0))
+	sessions.SessionsStorage.SetClock(clock)
+
+	r := httptest.NewRequest("GET", "/api/login", nil)
+	sess := sessions.SessionsStorage.StartNewSession(r, httptest.NewRecorder(), "bob")
+
+	clock.Add(1799 * time.Second)
+	sessions.SessionsStorage.CleanExpired()
+	if _, ok := sessions.SessionsStorage.GetAll()[sess.ID]; !ok {
+		t.Fatal("session expired too early")
+	}
+
+	clock.Add(2 * time.Second)
+	sessions.SessionsStorage.CleanExpired()
+	if _, ok := sessions.SessionsStorage.GetAll()[sess.ID]; ok {
+		t.Fatal("session has not expired")
+	}
+}
@@ -0,0 +1,84 @@
+package ghttp
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/geeksteam/SHM-Backend/panicerr"
+	"github.com/geeksteam/ghttp/handlerutils"
+	"github.com/geeksteam/ghttp/sessions"
+	"github.com/geeksteam/ghttp/tokens"
+)
+
+var errTokenAuth = errors.New("API tokens can't be managed with API token or impersonated session.")
+
+// TokenRequest is a JSON request to create API token.
+type TokenRequest struct {
+	Name       string
+	Scopes     []string // Permissions like "dns:read"
+	Expires    int64    // Unixtime, 0 - never
+	AllowedIPs []string // IPs and CIDRs, any if empty
+}
+
+// CreatedToken is a JSON response with new API token, it's shown once.
+type CreatedToken struct {
+	Value string // Raw token for Authorization header
+	tokens.Token
+}
+
+// RevokeRequest is a JSON request to revoke API token.
+type RevokeRequest struct {
+	ID string
+}
+
+// HandleListTokens writes API tokens of session's user. Handlers of this file
+// manage own tokens and should be mounted with Route.AnyUser.
+func (router *Router) HandleListTokens(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess := cookieSession(r, s)
+	list, err := router.Tokens.List(sess.Username)
+	if err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	handlerUtils.WriteJSONBody(w, list)
+}
+
+// HandleCreateToken creates API token of session's user from JSON TokenRequest
+// and responds with CreatedToken.
+func (router *Router) HandleCreateToken(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess := cookieSession(r, s)
+	req := TokenRequest{}
+	handlerUtils.ParseJSONBody(w, r, &req)
+
+	raw, token, err := router.Tokens.Create(sess.Username, req.Name, req.Scopes, req.Expires, req.AllowedIPs, router.clock.Now())
+	if err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	token.Hash = ""
+	handlerUtils.WriteJSONBody(w, CreatedToken{Value: raw, Token: token})
+}
+
+// HandleRevokeToken revokes API token of session's user from JSON RevokeRequest.
+func (router *Router) HandleRevokeToken(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess := cookieSession(r, s)
+	req := RevokeRequest{}
+	handlerUtils.ParseJSONBody(w, r, &req)
+
+	if err := router.Tokens.Revoke(sess.Username, req.ID); err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	handlerUtils.SendOkStatus(w)
+}
+
+// cookieSession returns own cookie session of request, API tokens and
+// impersonated sessions can't manage tokens.
+func cookieSession(r *http.Request, s *sessions.Sessions) *sessions.Session {
+	sess, err := s.Get(r)
+	if err != nil {
+		panicerr.Core.Auth(err.Error())
+	}
+	// Tokens of impersonated user would outlive impersonation and hide admin
+	if sess.Auth != "" || sess.Impersonator != "" {
+		panicerr.Handlers.BadRequest(errTokenAuth)
+	}
+	return sess
+}
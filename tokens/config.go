@@ -0,0 +1,7 @@
+package tokens
+
+type Tokens struct {
+	BoltDBTokens       string `default:"./db/main.db" comment:"Path to db with API tokens, main db by default"`
+	BoltDBTokensBucket string `default:"APITokens" comment:"Name of API tokens bucket in main db"`
+	DataEncoding       string `default:"mspack" comment:"Encoding of values for boltdb storage. Values:[mspack, json]"`
+}
@@ -0,0 +1,222 @@
+// Package tokens provides long-lived API tokens for non-browser clients. Token
+// is sent as "Authorization: Bearer <id>.<secret>".
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/geeksteam/GoTools/boltdb"
+	"github.com/geeksteam/ghttp/permissions"
+)
+
+const (
+	idLength     = 8  // Bytes of public part
+	secretLength = 24 // Bytes of secret part
+	separator    = "."
+
+	// UsageSaveInterval is a number of seconds, for which usage of token from
+	// the same IP isn't saved again. It keeps BoltDB writes off most requests.
+	UsageSaveInterval = 60
+)
+
+var (
+	errMalformed = errors.New("Malformed API token.")
+	errInvalid   = errors.New("Invalid API token.")
+	errExpired   = errors.New("API token has expired.")
+	errIP        = errors.New("API token is not allowed from this IP.")
+	errNoScopes  = errors.New("API token should have scopes.")
+)
+
+// Create generates new token of username and stores it. Raw token is returned
+// once and can't be restored.
+func (t Tokens) Create(username, name string, scopes []string, expires int64, allowedIPs []string, now time.Time) (string, Token, error) {
+	raw, token, err := New(username, name, scopes, expires, allowedIPs, now)
+	if err != nil {
+		return "", token, err
+	}
+	if err := boltdb.DB(t.BoltDBTokens, t.DataEncoding).Bucket(t.BoltDBTokensBucket).Set(token.ID, token); err != nil {
+		return "", token, err
+	}
+	return raw, token, nil
+}
+
+// Authenticate finds token by raw value and checks it for request from IP.
+// Last usage of token is saved if UsageChanged.
+func (t Tokens) Authenticate(raw, IP string, now time.Time) (Token, error) {
+	id, _, err := split(raw)
+	if err != nil {
+		return Token{}, err
+	}
+
+	token := Token{}
+	if err := boltdb.DB(t.BoltDBTokens, t.DataEncoding).Bucket(t.BoltDBTokensBucket).Get(id, &token); err != nil {
+		return Token{}, errInvalid
+	}
+	before := token
+	if err := Check(&token, raw, IP, now); err != nil {
+		return Token{}, err
+	}
+	if !UsageChanged(before, token) {
+		return token, nil
+	}
+	if err := boltdb.DB(t.BoltDBTokens, t.DataEncoding).Bucket(t.BoltDBTokensBucket).Set(token.ID, token); err != nil {
+		return Token{}, err
+	}
+	return token, nil
+}
+
+// List returns tokens of username without hashes.
+func (t Tokens) List(username string) ([]Token, error) {
+	result := []Token{}
+	all, err := boltdb.DB(t.BoltDBTokens, t.DataEncoding).Bucket(t.BoltDBTokensBucket).GetAll(&Token{})
+	if err != nil {
+		return result, err
+	}
+	for _, v := range all {
+		token := *v.(*Token)
+		if token.Username == username {
+			token.Hash = ""
+			result = append(result, token)
+		}
+	}
+	return result, nil
+}
+
+// Revoke deletes token of username.
+func (t Tokens) Revoke(username, id string) error {
+	token := Token{}
+	if err := boltdb.DB(t.BoltDBTokens, t.DataEncoding).Bucket(t.BoltDBTokensBucket).Get(id, &token); err != nil || token.Username != username {
+		return errInvalid
+	}
+	return boltdb.DB(t.BoltDBTokens, t.DataEncoding).Bucket(t.BoltDBTokensBucket).Delete(id)
+}
+
+// New generates raw token and its stored representation.
+func New(username, name string, scopes []string, expires int64, allowedIPs []string, now time.Time) (string, Token, error) {
+	if len(scopes) == 0 {
+		return "", Token{}, errNoScopes
+	}
+	for _, s := range scopes {
+		if _, err := permissions.Parse(s); err != nil {
+			return "", Token{}, err
+		}
+	}
+	for _, ip := range allowedIPs {
+		if parseNet(ip) == nil {
+			return "", Token{}, errors.New("Invalid IP or CIDR " + ip)
+		}
+	}
+
+	id, err := random(idLength)
+	if err != nil {
+		return "", Token{}, err
+	}
+	secret, err := random(secretLength)
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	token := Token{
+		ID:         id,
+		Name:       name,
+		Username:   username,
+		Hash:       hash(secret),
+		Scopes:     scopes,
+		AllowedIPs: allowedIPs,
+		Created:    now.Unix(),
+		Expires:    expires,
+	}
+	return id + separator + secret, token, nil
+}
+
+// Check checks raw token against stored one for request from IP and marks
+// it used. Caller stores changed token.
+func Check(token *Token, raw, IP string, now time.Time) error {
+	id, secret, err := split(raw)
+	if err != nil {
+		return err
+	}
+	if id != token.ID || subtle.ConstantTimeCompare([]byte(hash(secret)), []byte(token.Hash)) != 1 {
+		return errInvalid
+	}
+	if token.Expires > 0 && now.Unix() >= token.Expires {
+		return errExpired
+	}
+	if !allowedIP(token.AllowedIPs, IP) {
+		return errIP
+	}
+
+	token.LastUsed = now.Unix()
+	token.LastIP = IP
+	return nil
+}
+
+// UsageChanged checks if usage of token after Check differs from before
+// enough to be saved: IP changed or UsageSaveInterval passed.
+func UsageChanged(before, after Token) bool {
+	return after.LastIP != before.LastIP || after.LastUsed-before.LastUsed >= UsageSaveInterval
+}
+
+func split(raw string) (string, string, error) {
+	parts := strings.SplitN(raw, separator, 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errMalformed
+	}
+	return parts[0], parts[1], nil
+}
+
+func allowedIP(allowed []string, IP string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	ip := net.ParseIP(IP)
+	if ip == nil {
+		return false
+	}
+	for _, a := range allowed {
+		if n := parseNet(a); n != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNet parses CIDR or single IP.
+func parseNet(s string) *net.IPNet {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil
+	}
+	return n
+}
+
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
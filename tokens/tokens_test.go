@@ -0,0 +1,76 @@
+package tokens_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geeksteam/ghttp/tokens"
+)
+
+func TestCheck(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	raw, token, err := tokens.New("bob", "backup", []string{"backups:read"}, now.Unix()+60, []string{"192.0.2.0/24", "2001:db8::1"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(token.Hash, strings.SplitN(raw, ".", 2)[1]) {
+		t.Error("Secret is stored in plain text")
+	}
+
+	if err := tokens.Check(&token, raw, "192.0.2.10", now); err != nil {
+		t.Error(err)
+	}
+	if token.LastUsed != now.Unix() || token.LastIP != "192.0.2.10" {
+		t.Errorf("Usage is not saved: %+v", token)
+	}
+	if err := tokens.Check(&token, raw, "2001:db8::1", now); err != nil {
+		t.Error(err)
+	}
+	if err := tokens.Check(&token, raw, "198.51.100.1", now); err == nil {
+		t.Error("Token should not be allowed from IP out of allowlist")
+	}
+	if err := tokens.Check(&token, raw+"x", "192.0.2.10", now); err == nil {
+		t.Error("Wrong secret should be refused")
+	}
+	if err := tokens.Check(&token, raw, "192.0.2.10", now.Add(time.Minute)); err == nil {
+		t.Error("Expired token should be refused")
+	}
+}
+
+func TestUsageChanged(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	raw, token, err := tokens.New("bob", "backup", []string{"backups:read"}, 0, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(IP string, at time.Time) bool {
+		before := token
+		if err := tokens.Check(&token, raw, IP, at); err != nil {
+			t.Fatal(err)
+		}
+		return tokens.UsageChanged(before, token)
+	}
+	if !check("192.0.2.10", now) {
+		t.Error("First usage should be saved")
+	}
+	if check("192.0.2.10", now.Add(time.Second)) {
+		t.Error("Repeated usage from the same IP should not be saved")
+	}
+	if !check("192.0.2.11", now.Add(2*time.Second)) {
+		t.Error("Usage from another IP should be saved")
+	}
+	if !check("192.0.2.11", now.Add(2*time.Second+tokens.UsageSaveInterval*time.Second)) {
+		t.Error("Usage after UsageSaveInterval should be saved")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, _, err := tokens.New("bob", "none", nil, 0, nil, time.Now()); err == nil {
+		t.Error("Token without scopes should be refused")
+	}
+	if _, _, err := tokens.New("bob", "bad", []string{"dns:read"}, 0, []string{"not an ip"}, time.Now()); err == nil {
+		t.Error("Invalid allowlist should be refused")
+	}
+}
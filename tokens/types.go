@@ -0,0 +1,15 @@
+package tokens
+
+// Token is an API token stored in BoltDB, secret part is kept as hash only.
+type Token struct {
+	ID         string   // Public part of token, key in bucket
+	Name       string   // Name given by user, like "backup script"
+	Username   string   // Owner of token
+	Hash       string   `json:",omitempty"` // SHA-256 of secret part
+	Scopes     []string // Permissions like "dns:read", token can't exceed owner's permissions
+	AllowedIPs []string // IPs and CIDRs token may be used from, any if empty
+	Created    int64    // Unixtime
+	Expires    int64    // Unixtime, 0 - never
+	LastUsed   int64    // Unixtime
+	LastIP     string
+}
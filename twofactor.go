@@ -0,0 +1,194 @@
+package ghttp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/geeksteam/GoTools/logger"
+	"github.com/geeksteam/SHM-Backend/panicerr"
+	"github.com/geeksteam/ghttp/handlerutils"
+	"github.com/geeksteam/ghttp/journal"
+	"github.com/geeksteam/ghttp/sessions"
+)
+
+const (
+	// AuthRequiredHeader tells client which authentication is required with 401 status.
+	AuthRequiredHeader = "X-Auth-Required"
+	// AuthSecondFactor means session waits for second factor code.
+	AuthSecondFactor = "second-factor"
+	// AuthStepUp means route requires recent authentication.
+	AuthStepUp = "step-up"
+
+	// secondFactorOperation is a journal operation of second factor changes.
+	secondFactorOperation = "twofactor"
+)
+
+var (
+	errNoPendingFactor     = errors.New("Session doesn't wait for second factor.")
+	errImpersonatedFactor  = errors.New("Second factor can't be changed from impersonated session.")
+	errSecondFactorUnknown = errors.New("Can't check second factor, try again later.")
+)
+
+// CodeRequest is a JSON request with TOTP or recovery code.
+type CodeRequest struct {
+	Code string
+}
+
+// Enrollment is a JSON response with new secret for authenticator app.
+type Enrollment struct {
+	Secret string
+	URI    string // otpauth:// URI for QR code
+}
+
+// RecoveryCodes is a JSON response with recovery codes, they are shown once.
+type RecoveryCodes struct {
+	RecoveryCodes []string
+}
+
+// StartSession starts session of user who passed password check in login
+// handler. Users with second factor get pending session, which is usable after
+// HandleSecondFactor only. Login is refused if it's unknown whether user has
+// second factor.
+func (router *Router) StartSession(r *http.Request, w http.ResponseWriter, username string) *sessions.Session {
+	enabled, err := router.SecondFactor.Enabled(username)
+	if err != nil {
+		logger.Error("Can't check second factor of " + username + ": " + err.Error())
+		panicerr.Core.Auth(errSecondFactorUnknown.Error())
+	}
+	if enabled {
+		return sessions.SessionsStorage.StartPendingSession(r, w, username)
+	}
+	return sessions.SessionsStorage.StartNewSession(r, w, username)
+}
+
+// HandleSecondFactor checks code from JSON CodeRequest for pending session.
+// Should be mounted with HandleLoginFunc, wrong codes are counted by Guard
+// like wrong passwords.
+func (router *Router) HandleSecondFactor(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess, err := s.Get(r)
+	if err != nil {
+		http.Error(w, http.StatusText(401), 401)
+		return
+	}
+	if !sess.SecondFactorPending {
+		panicerr.Handlers.BadRequest(errNoPendingFactor)
+	}
+	if router.verifyCode(w, r, sess) {
+		handlerUtils.SendOkStatus(w)
+	}
+}
+
+// HandleStepUp renews authentication of session with code from JSON
+// CodeRequest for StepUp routes. Should be mounted with Route.AnyUser. Users
+// without second factor should reauthenticate with password in own handler,
+// which calls Sessions.Reauthenticated.
+func (router *Router) HandleStepUp(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess, err := s.Get(r)
+	if err != nil {
+		panicerr.Core.Auth(err.Error())
+	}
+	if router.verifyCode(w, r, sess) {
+		handlerUtils.SendOkStatus(w)
+	}
+}
+
+// HandleEnroll generates new second factor secret for session's user. It's
+// required on login after HandleConfirm only. Should be mounted with Route.AnyUser.
+func (router *Router) HandleEnroll(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess := ownSession(r, s)
+	secret, uri, err := router.SecondFactor.Enroll(sess.Username)
+	if err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	handlerUtils.WriteJSONBody(w, Enrollment{Secret: secret, URI: uri})
+}
+
+// HandleConfirm enables second factor of session's user with code from JSON
+// CodeRequest and responds with RecoveryCodes. Should be mounted with Route.AnyUser.
+func (router *Router) HandleConfirm(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess := ownSession(r, s)
+	req := CodeRequest{}
+	handlerUtils.ParseJSONBody(w, r, &req)
+
+	codes, err := router.SecondFactor.Confirm(sess.Username, req.Code, router.clock.Now())
+	if err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	router.journalSecondFactor(sess, "enabled")
+	handlerUtils.WriteJSONBody(w, RecoveryCodes{RecoveryCodes: codes})
+}
+
+// HandleDisable disables second factor of session's user. Should be mounted
+// with Route.AnyUser and Route.StepUp.
+func (router *Router) HandleDisable(w http.ResponseWriter, r *http.Request, s *sessions.Sessions) {
+	sess := ownSession(r, s)
+	if err := router.SecondFactor.Disable(sess.Username); err != nil {
+		panicerr.Handlers.BadRequest(err)
+	}
+	router.journalSecondFactor(sess, "disabled")
+	handlerUtils.SendOkStatus(w)
+}
+
+// ownSession returns session of request, which is not impersonated, so
+// second factor is changed by its owner only.
+func ownSession(r *http.Request, s *sessions.Sessions) *sessions.Session {
+	sess, err := s.Get(r)
+	if err != nil {
+		panicerr.Core.Auth(err.Error())
+	}
+	if sess.Impersonator != "" {
+		panicerr.Handlers.BadRequest(errImpersonatedFactor)
+	}
+	return sess
+}
+
+// verifyCode checks code from JSON CodeRequest under Guard's login protection
+// and marks session as authenticated. It writes error response and returns
+// false if code is wrong.
+func (router *Router) verifyCode(w http.ResponseWriter, r *http.Request, sess *sessions.Session) bool {
+	if ok, wait := router.Guard.LoginCheck(clientIP(r), sess.Username); !ok {
+		w.Header().Set("Retry-After", strconv.FormatInt(wait, 10))
+		http.Error(w, http.StatusText(429), 429)
+		return false
+	}
+
+	req := CodeRequest{}
+	handlerUtils.ParseJSONBody(w, r, &req)
+	if err := router.SecondFactor.Verify(sess.Username, req.Code, router.clock.Now()); err != nil {
+		router.Guard.LoginFailed(clientIP(r), sess.Username)
+		logger.Warning("Second factor of " + sess.Username + " failed from " + clientIP(r) + ": " + err.Error())
+		w.Header().Set(AuthRequiredHeader, AuthSecondFactor)
+		http.Error(w, http.StatusText(401), 401)
+		return false
+	}
+	router.Guard.LoginSucceeded(clientIP(r), sess.Username)
+
+	if err := sessions.SessionsStorage.Reauthenticated(r); err != nil {
+		panicerr.Core.Auth(err.Error())
+	}
+	router.journalSecondFactor(sess, "verified")
+	return true
+}
+
+// recentlyAuthenticated checks if session passed authentication within StepUpTime.
+func (router *Router) recentlyAuthenticated(sess *sessions.Session) bool {
+	return router.clock.Now().Unix()-sess.AuthTime <= cfg.TwoFactor.StepUpTime
+}
+
+// authRequired responds with 401 and kind of required authentication.
+func authRequired(w http.ResponseWriter, kind string) {
+	w.Header().Set(AuthRequiredHeader, kind)
+	http.Error(w, http.StatusText(401), 401)
+}
+
+func (router *Router) journalSecondFactor(sess *sessions.Session, content string) {
+	router.addJournal(journal.Operation{
+		SessionID:    sess.ID,
+		Date:         router.clock.Now().Format(journal.TimeLayout),
+		Username:     sess.Username,
+		Operation:    secondFactorOperation,
+		Content:      content,
+		Impersonator: sess.Impersonator,
+	})
+}
@@ -0,0 +1,13 @@
+package twofactor
+
+type TwoFactor struct {
+	BoltDBTwoFactor       string `default:"./db/main.db" comment:"Path to db with second factor secrets, main db by default"`
+	BoltDBTwoFactorBucket string `default:"TwoFactor" comment:"Name of second factor bucket in main db"`
+	DataEncoding          string `default:"mspack" comment:"Encoding of values for boltdb storage. Values:[mspack, json]"`
+	Issuer                string `default:"SHM" comment:"Issuer name shown in authenticator apps"`
+	Digits                int    `default:"6" comment:"Number of digits in TOTP codes"`
+	Period                int64  `default:"30" comment:"TOTP time step. Seconds."`
+	Skew                  int64  `default:"1" comment:"Number of time steps before and after current one accepted for clock drift"`
+	RecoveryCodes         int    `default:"10" comment:"Number of recovery codes given on enrollment"`
+	StepUpTime            int64  `default:"300" comment:"Authentication is recent enough for step-up routes during this time. Seconds."`
+}
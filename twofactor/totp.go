@@ -0,0 +1,82 @@
+package twofactor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// secretLength is a length of generated secrets in bytes, as recommended by RFC 4226.
+const secretLength = 20
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns random base32 encoded secret.
+func GenerateSecret() (string, error) {
+	key := make([]byte, secretLength)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(key), nil
+}
+
+// Counter returns RFC 6238 time step number of t.
+func Counter(t time.Time, period int64) int64 {
+	return t.Unix() / period
+}
+
+// Code returns RFC 4226 HOTP code of base32 secret for counter.
+func Code(secret string, counter int64, digits int) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod), nil
+}
+
+// Match returns counter of code within skew time steps around t, ok is false
+// if code doesn't match.
+func Match(secret, code string, t time.Time, period, skew int64, digits int) (int64, bool) {
+	current := Counter(t, period)
+	for counter := current - skew; counter <= current+skew; counter++ {
+		expected, err := Code(secret, counter, digits)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns otpauth:// URI for QR codes of authenticator apps.
+func URI(issuer, account, secret string, period int64, digits int) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("period", fmt.Sprint(period))
+	v.Set("digits", fmt.Sprint(digits))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + v.Encode()
+}
@@ -0,0 +1,47 @@
+package twofactor_test
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/geeksteam/ghttp/twofactor"
+)
+
+// RFC 6238 Appendix B test vectors for SHA1
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, expected := range vectors {
+		code, err := twofactor.Code(secret, twofactor.Counter(time.Unix(unix, 0), 30), 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("Code at %v is %v, expected %v", unix, code, expected)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	secret, err := twofactor.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1500000000, 0)
+	code, _ := twofactor.Code(secret, twofactor.Counter(now, 30), 6)
+
+	if _, ok := twofactor.Match(secret, code, now.Add(30*time.Second), 30, 1, 6); !ok {
+		t.Error("Code of previous step should match with skew 1")
+	}
+	if _, ok := twofactor.Match(secret, code, now.Add(90*time.Second), 30, 1, 6); ok {
+		t.Error("Code should not match out of skew")
+	}
+}
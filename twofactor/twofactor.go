@@ -0,0 +1,228 @@
+package twofactor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/geeksteam/GoTools/boltdb"
+)
+
+const (
+	dbMode = os.FileMode(0600)
+	// openTimeout limits waiting for file lock held by other users of db.
+	openTimeout = time.Second
+)
+
+var (
+	errNotEnrolled     = errors.New("Second factor is not enabled.")
+	errAlreadyEnrolled = errors.New("Second factor is already enabled.")
+	errInvalidCode     = errors.New("Invalid code.")
+)
+
+// Enroll generates new secret for username and returns it with otpauth URI.
+// Second factor isn't required until it's confirmed with Confirm.
+func (t TwoFactor) Enroll(username string) (string, string, error) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	err = t.update(username, func(e *Enrollment, found bool) error {
+		if found && e.Confirmed {
+			return errAlreadyEnrolled
+		}
+		*e = Enrollment{Secret: secret}
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return secret, URI(t.Issuer, username, secret, t.Period, t.Digits), nil
+}
+
+// Confirm enables second factor of username if code is valid and returns
+// recovery codes, they are shown once and stored hashed.
+func (t TwoFactor) Confirm(username, code string, now time.Time) ([]string, error) {
+	var codes []string
+	err := t.update(username, func(e *Enrollment, found bool) error {
+		if !found {
+			return errNotEnrolled
+		}
+		var err error
+		codes, err = t.Activate(e, code, now)
+		return err
+	})
+	return codes, err
+}
+
+// Verify checks TOTP or recovery code of username. Accepted TOTP codes and
+// recovery codes can't be used again: check and update are done in one
+// transaction, so concurrent requests with the same code can't both pass.
+func (t TwoFactor) Verify(username, code string, now time.Time) error {
+	return t.update(username, func(e *Enrollment, found bool) error {
+		if !found {
+			return errNotEnrolled
+		}
+		return t.Check(e, code, now)
+	})
+}
+
+// Activate confirms enrollment if code is valid and sets its recovery codes,
+// which are returned in plain text. Caller stores changed enrollment.
+func (t TwoFactor) Activate(e *Enrollment, code string, now time.Time) ([]string, error) {
+	if e.Confirmed {
+		return nil, errAlreadyEnrolled
+	}
+
+	counter, ok := Match(e.Secret, code, now, t.Period, t.Skew, t.Digits)
+	if !ok {
+		return nil, errInvalidCode
+	}
+
+	codes, hashes, err := t.recoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	e.Confirmed = true
+	e.LastCounter = counter
+	e.RecoveryCodes = hashes
+	return codes, nil
+}
+
+// Check checks TOTP or recovery code against confirmed enrollment and marks
+// code used. Caller stores changed enrollment.
+func (t TwoFactor) Check(e *Enrollment, code string, now time.Time) error {
+	if !e.Confirmed {
+		return errNotEnrolled
+	}
+
+	if counter, ok := Match(e.Secret, code, now, t.Period, t.Skew, t.Digits); ok {
+		if counter <= e.LastCounter {
+			return errInvalidCode
+		}
+		e.LastCounter = counter
+		return nil
+	}
+
+	hash := hashCode(code)
+	for i, h := range e.RecoveryCodes {
+		if h == hash {
+			e.RecoveryCodes = append(e.RecoveryCodes[:i], e.RecoveryCodes[i+1:]...)
+			return nil
+		}
+	}
+	return errInvalidCode
+}
+
+// Enabled checks if username has confirmed second factor. Error is returned
+// if it can't be found out, login should be refused then.
+func (t TwoFactor) Enabled(username string) (bool, error) {
+	e, err := t.get(username)
+	if err == errNotEnrolled {
+		return false, nil
+	}
+	return err == nil && e.Confirmed, err
+}
+
+// Disable removes second factor of username.
+func (t TwoFactor) Disable(username string) error {
+	db, err := t.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(t.BoltDBTwoFactorBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(username))
+	})
+}
+
+// get returns enrollment of username, errNotEnrolled if there is none.
+func (t TwoFactor) get(username string) (Enrollment, error) {
+	e := Enrollment{}
+	db, err := t.open()
+	if err != nil {
+		return e, err
+	}
+	defer db.Close()
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(t.BoltDBTwoFactorBucket))
+		if bucket == nil {
+			return errNotEnrolled
+		}
+		v := bucket.Get([]byte(username))
+		if v == nil {
+			return errNotEnrolled
+		}
+		return boltdb.DecodeValue(v, &e, t.DataEncoding)
+	})
+	return e, err
+}
+
+// update changes enrollment of username with f in one transaction, it's
+// saved if f returns no error.
+func (t TwoFactor) update(username string, f func(e *Enrollment, found bool) error) error {
+	db, err := t.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(t.BoltDBTwoFactorBucket))
+		if err != nil {
+			return err
+		}
+
+		e := Enrollment{}
+		v := bucket.Get([]byte(username))
+		if v != nil {
+			if err := boltdb.DecodeValue(v, &e, t.DataEncoding); err != nil {
+				return err
+			}
+		}
+		if err := f(&e, v != nil); err != nil {
+			return err
+		}
+
+		value, err := boltdb.EncodeValue(e, t.DataEncoding)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(username), value)
+	})
+}
+
+// open opens BoltDB of second factors, it's shared with other users of main
+// db, so it's kept open for one operation only.
+func (t TwoFactor) open() (*bolt.DB, error) {
+	return bolt.Open(t.BoltDBTwoFactor, dbMode, &bolt.Options{Timeout: openTimeout})
+}
+
+// recoveryCodes generates recovery codes like "1a2b3c-4d5e6f" and their hashes.
+func (t TwoFactor) recoveryCodes() ([]string, []string, error) {
+	codes := make([]string, t.RecoveryCodes)
+	hashes := make([]string, t.RecoveryCodes)
+	for i := range codes {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		codes[i] = fmt.Sprintf("%x-%x", b[:3], b[3:])
+		hashes[i] = hashCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
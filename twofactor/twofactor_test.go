@@ -0,0 +1,127 @@
+package twofactor_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geeksteam/ghttp/twofactor"
+)
+
+func newTwoFactor(t *testing.T) twofactor.TwoFactor {
+	dir, err := ioutil.TempDir("", "twofactor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return twofactor.TwoFactor{
+		BoltDBTwoFactor:       filepath.Join(dir, "main.db"),
+		BoltDBTwoFactorBucket: "TwoFactor",
+		DataEncoding:          "json",
+		Issuer:                "SHM",
+		Digits:                6,
+		Period:                30,
+		Skew:                  1,
+		RecoveryCodes:         2,
+	}
+}
+
+func TestEnabledBrokenDB(t *testing.T) {
+	tf := newTwoFactor(t)
+	if err := ioutil.WriteFile(tf.BoltDBTwoFactor, []byte("not a bolt db"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	enabled, err := tf.Enabled("user")
+	if err == nil {
+		t.Fatal("Broken db gives no error")
+	}
+	if enabled {
+		t.Error("Second factor is disabled on error")
+	}
+}
+
+func TestEnroll(t *testing.T) {
+	tf := newTwoFactor(t)
+	now := time.Now()
+
+	if enabled, err := tf.Enabled("user"); err != nil || enabled {
+		t.Fatalf("Enabled without enrollment: %v, %v", enabled, err)
+	}
+	secret, _, err := tf.Enroll("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := tf.Enabled("user"); err != nil || enabled {
+		t.Fatalf("Enabled before confirmation: %v, %v", enabled, err)
+	}
+
+	code, _ := twofactor.Code(secret, twofactor.Counter(now, tf.Period), tf.Digits)
+	codes, err := tf.Confirm("user", code, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != tf.RecoveryCodes {
+		t.Errorf("%v recovery codes, expected %v", len(codes), tf.RecoveryCodes)
+	}
+	if enabled, err := tf.Enabled("user"); err != nil || !enabled {
+		t.Fatalf("Enabled after confirmation: %v, %v", enabled, err)
+	}
+	if _, _, err := tf.Enroll("user"); err == nil {
+		t.Error("Confirmed second factor is enrolled again")
+	}
+
+	if err := tf.Verify("user", codes[0], now); err != nil {
+		t.Error(err)
+	}
+	if err := tf.Verify("user", codes[0], now); err == nil {
+		t.Error("Recovery code is accepted twice")
+	}
+
+	if err := tf.Disable("user"); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := tf.Enabled("user"); err != nil || enabled {
+		t.Fatalf("Enabled after disable: %v, %v", enabled, err)
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	tf := newTwoFactor(t)
+	now := time.Now()
+
+	secret, _, err := tf.Enroll("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := twofactor.Code(secret, twofactor.Counter(now, tf.Period)-1, tf.Digits)
+	if _, err := tf.Confirm("user", code, now); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _ = twofactor.Code(secret, twofactor.Counter(now, tf.Period), tf.Digits)
+	passed := make(chan bool, 10)
+	wg := sync.WaitGroup{}
+	for i := 0; i < cap(passed); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			passed <- tf.Verify("user", code, now) == nil
+		}()
+	}
+	wg.Wait()
+	close(passed)
+
+	count := 0
+	for ok := range passed {
+		if ok {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("Code is accepted %v times, expected once", count)
+	}
+}
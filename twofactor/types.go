@@ -0,0 +1,9 @@
+package twofactor
+
+// Enrollment is a second factor of user stored in BoltDB.
+type Enrollment struct {
+	Secret        string   // Base32 TOTP secret
+	Confirmed     bool     // User proved that authenticator app works
+	LastCounter   int64    // Time step of last accepted code, codes can't be reused
+	RecoveryCodes []string // SHA-256 hashes of unused recovery codes
+}
@@ -1,9 +1,17 @@
 package ghttp
 
 import (
+	"net/http"
 	"sync"
+	"time"
 
+	"github.com/geeksteam/ghttp/bruteforce"
+	"github.com/geeksteam/ghttp/clock"
+	"github.com/geeksteam/ghttp/cors"
+	"github.com/geeksteam/ghttp/hmacauth"
+	"github.com/geeksteam/ghttp/roles"
 	"github.com/geeksteam/ghttp/sessions"
+	"github.com/geeksteam/ghttp/tokens"
 	"github.com/gorilla/mux"
 )
 
@@ -19,9 +27,76 @@ type rhandler struct {
 
 // Router is a custom gorilla's Router wrapper.
 type Router struct {
-	curID      uint64              // Counter total handlers done
-	handlers   map[uint64]rhandler // List of running handlers
-	Sessions   *sessions.Sessions  // User's sessions
-	mutex      sync.RWMutex
-	mux.Router // Include mux router composition
+	curID        uint64                  // Counter total handlers done
+	handlers     map[uint64]rhandler     // List of running handlers
+	routes       []*Route                // Routes registered by Handle*Func
+	Sessions     *sessions.Sessions      // User's sessions
+	Guard        *bruteforce.Guard       // Bruteforce protection
+	Users        UserProvider            // Users info for permissions checks
+	Roles        RoleProvider            // Users roles for permissions checks
+	SecondFactor SecondFactorProvider    // Users TOTP second factor
+	Tokens       TokenProvider           // API tokens for Authorization: Bearer
+	Signatures   *hmacauth.Authenticator // HMAC signed requests, nil to use config
+	CORS         *cors.Policy            // Default CORS policy of routes, nil to use config
+	PostHandler  PostHandlerHook         // Runs after every handler
+	Errors       ErrorReporter           // Reports unknown panics
+	Lifecycle    LifecycleWatcher        // Tracks running handlers for graceful shutdown
+	clock        clock.Clock
+	mutex        sync.RWMutex
+	mux.Router   // Include mux router composition
+}
+
+// UserProvider gives users info needed for permissions checks.
+type UserProvider interface {
+	// Permissions returns user's permissions like "dns:read", empty list
+	// denies everything.
+	Permissions(username string) ([]string, error)
+}
+
+// RoleProvider gives users roles. Role changes should be visible on next call,
+// so they apply to live sessions at once.
+type RoleProvider interface {
+	UserRoles(username string) ([]roles.Role, error)
+}
+
+// PostHandlerHook runs after every handler, sess is nil for login handlers.
+type PostHandlerHook interface {
+	AfterHandler(w http.ResponseWriter, r *http.Request, sess *sessions.Session)
+}
+
+// ErrorReporter reports unknown panics caught in handlers.
+type ErrorReporter interface {
+	CaptureError(err error)
+}
+
+// LifecycleWatcher tracks running handlers, Start returns error when server is
+// shutting down and new handlers should not run.
+type LifecycleWatcher interface {
+	Start() error
+	Finish()
+}
+
+// SecondFactorProvider enrolls and verifies users second factor,
+// twofactor.TwoFactor stores it in BoltDB.
+type SecondFactorProvider interface {
+	// Enabled checks if user should pass second factor on login, error means
+	// it's unknown and login is refused.
+	Enabled(username string) (bool, error)
+	// Enroll returns new secret of user and its otpauth URI.
+	Enroll(username string) (string, string, error)
+	// Confirm enables second factor if code is valid and returns recovery codes.
+	Confirm(username, code string, now time.Time) ([]string, error)
+	// Verify checks TOTP or recovery code of user.
+	Verify(username, code string, now time.Time) error
+	Disable(username string) error
+}
+
+// TokenProvider stores API tokens, tokens.Tokens stores them in BoltDB.
+type TokenProvider interface {
+	// Authenticate checks raw token for request from IP and saves its usage.
+	Authenticate(raw, IP string, now time.Time) (tokens.Token, error)
+	// Create returns raw token, which is shown once, and its stored representation.
+	Create(username, name string, scopes []string, expires int64, allowedIPs []string, now time.Time) (string, tokens.Token, error)
+	List(username string) ([]tokens.Token, error)
+	Revoke(username, id string) error
 }
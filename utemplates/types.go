@@ -2,9 +2,23 @@ package utemplates
 
 // UserTemplate Структура шаблона прав юзера для базы
 type UserTemplate struct {
-	Modules []string   // Список с названием доступных пользователю модулей панели (dns, mysql, www...)
-	SSH     bool       // Есть ли доступ в ssh у пользователя (установлен ли bash или nologin, если доступа нет).
-	Limits  UserLimits // Ограничения пользователя. Структура уже описана в bolt/users.go
+	Modules     []string   // Список с названием доступных пользователю модулей панели (dns, mysql, www...)
+	Permissions []string   // Права вида module:action (dns:read, dns:write, filemanager:*), "*" - полный доступ
+	SSH         bool       // Есть ли доступ в ssh у пользователя (установлен ли bash или nologin, если доступа нет).
+	Limits      UserLimits // Ограничения пользователя. Структура уже описана в bolt/users.go
+}
+
+// Grants returns template's permissions. Templates without Permissions grant
+// all actions in their Modules, templates without both deny everything.
+func (t UserTemplate) Grants() []string {
+	if len(t.Permissions) > 0 {
+		return t.Permissions
+	}
+	grants := make([]string, 0, len(t.Modules))
+	for _, module := range t.Modules {
+		grants = append(grants, module+":*")
+	}
+	return grants
 }
 
 // UserLimits Ограничения пользователя